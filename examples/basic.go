@@ -27,19 +27,19 @@ func main() {
 	req.Header.Add("Accept", "text/*")
 
 	newOpts := client.Options
-	client.ThreadPool.Rate.ChangeRate(4)
+	client.ThreadPool.ChangeRate(4)
 
-	var last *httpc.MessageDuplex
+	var last *httpc.Future
 	for i := 0; i < 20; i++ {
 		last = client.SendWithOptions(req, newOpts)
 	}
 
-	<-last.Resolved
-	respData, _ := httputil.DumpResponse(last.Response, false)
+	client.Wait(context.Background())
+	respData, _ := httputil.DumpResponse(last.Message().Response, false)
 	fmt.Println(string(respData))
 
 	newOpts = client.Options
-	client.ThreadPool.Rate.ChangeRate(30)
+	client.ThreadPool.ChangeRate(30)
 
 	go http.ListenAndServe("localhost:6060", nil)
 	pprof.Lookup("goroutine").WriteTo(os.Stdout, 1)
@@ -1,101 +1,246 @@
 package rate
 
 import (
-	"container/ring"
+	"context"
+	"math"
 	"sync"
 	"time"
 )
 
+// Unlimited disables rate limiting, any non-positive rate has the same effect.
+const Unlimited float64 = 0
+
+const (
+	bucketWidth = 100 * time.Millisecond
+	bucketCount = 50
+
+	// throttling never slows the rate down below this fraction of the configured rate
+	minThrottledFraction = 0.01
+)
+
+// RateThrottle is a token bucket rate limiter that also keeps track of the
+// rate at which requests actually complete.
 type RateThrottle struct {
-	RPS int
-
-	RateLimiter *time.Ticker
-	rateCounter *ring.Ring
-	rateMutex   sync.Mutex
-	throttleRate uint64
-}
-
-func NewRateThrottle(rate int) *RateThrottle {
-	r := &RateThrottle{
-		RPS: rate,
-	}
-
-	ratemicros := 1000000/r.RPS - 50000/r.RPS
-	r.RateLimiter = time.NewTicker(time.Microsecond * time.Duration(ratemicros))
-	r.rateCounter = ring.New(r.RPS * 5)
-	return r
-}
-
-// CurrentRate calculates requests/second value from circular list of rate
-func (r *RateThrottle) CurrentRate() int64 {
-	n := r.rateCounter.Len()
-	lowest := int64(0)
-	highest := int64(0)
-	r.rateCounter.Do(func(r interface{}) {
-		switch val := r.(type) {
-		case int64:
-			if lowest == 0 || val < lowest {
-				lowest = val
-			}
-			if val > highest {
-				highest = val
-			}
-		default:
-			// circular list entry was nil, happens when < number_of_threads * 5 responses have been recorded.
-			// the total number of entries is less than length of the list
-			n -= 1
+	rateMutex sync.Mutex
+
+	rps                float64
+	burst              int
+	tokens             float64
+	lastRefill         time.Time
+	throttlePercentage uint8
+	changed            chan struct{}
+
+	bucketIdx [bucketCount]int64
+	buckets   [bucketCount]int64
+	firstTick time.Time
+}
+
+func NewRateThrottle(rps float64, burst int) *RateThrottle {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &RateThrottle{
+		rps:        rps,
+		burst:      burst,
+		tokens:     float64(burst),
+		lastRefill: time.Now(),
+		changed:    make(chan struct{}),
+	}
+}
+
+// Wait blocks until a token is available or the context is done,
+// rate changes while waiting take effect immediately.
+func (r *RateThrottle) Wait(ctx context.Context) error {
+	for {
+		r.rateMutex.Lock()
+		delay := r.reserve(time.Now())
+		changed := r.changed
+		r.rateMutex.Unlock()
+
+		if delay == 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			r.cancelReservation()
+			return ctx.Err()
+		case <-changed:
+			timer.Stop()
+			r.cancelReservation()
+		case <-timer.C:
+			return nil
 		}
-	})
+	}
+}
+
+// reserve takes a token and returns how long to wait until it becomes
+// available. Must be called with rateMutex held.
+func (r *RateThrottle) reserve(now time.Time) time.Duration {
+	if r.rps <= 0 {
+		return 0
+	}
+
+	r.refill(now)
+	r.tokens--
+
+	if r.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(math.Ceil(-r.tokens / r.effectiveRate() * float64(time.Second)))
+}
+
+func (r *RateThrottle) cancelReservation() {
+	r.rateMutex.Lock()
+	defer r.rateMutex.Unlock()
+
+	r.refill(time.Now())
+	r.tokens = math.Min(r.tokens+1, float64(r.burst))
+}
+
+func (r *RateThrottle) throttleRate() float64 {
+	return r.rps / 100 * float64(r.throttlePercentage)
+}
+
+func (r *RateThrottle) effectiveRate() float64 {
+	return math.Max(r.rps-r.throttleRate(), r.rps*minThrottledFraction)
+}
+
+// ChangeRate updates the desired requests per second, the measured rate and
+// accumulated tokens are preserved.
+func (r *RateThrottle) ChangeRate(rps float64) {
+	r.rateMutex.Lock()
+	defer r.rateMutex.Unlock()
+
+	r.refill(time.Now())
+	r.rps = rps
+	r.notifyChange()
+}
+
+// SetBurst updates the maximum number of requests that can be sent at once.
+func (r *RateThrottle) SetBurst(burst int) {
+	if burst < 1 {
+		burst = 1
+	}
 
-	earliest := time.UnixMicro(lowest)
-	latest := time.UnixMicro(highest)
-	elapsed := latest.Sub(earliest)
-	if n > 0 && elapsed.Milliseconds() > 1 {
-		return int64(1000 * int64(n) / elapsed.Milliseconds())
+	r.rateMutex.Lock()
+	defer r.rateMutex.Unlock()
+
+	r.refill(time.Now())
+	r.burst = burst
+	if r.tokens > float64(burst) {
+		r.tokens = float64(burst)
 	}
-	return 0
+	r.notifyChange()
 }
 
-func (r *RateThrottle) ChangeRate(rate int) {
-	if rate <= 0 {
-		return
+func (r *RateThrottle) refill(now time.Time) {
+	if r.rps > 0 {
+		r.tokens = math.Min(r.tokens+now.Sub(r.lastRefill).Seconds()*r.effectiveRate(), float64(r.burst))
 	}
+	r.lastRefill = now
+}
 
-	r.RPS = rate
-	r.RateLimiter.Stop()
+func (r *RateThrottle) notifyChange() {
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
 
-	ratemicros := 1000000/r.RPS - 50000/r.RPS
+// RPS returns the desired requests per second, zero means unlimited.
+func (r *RateThrottle) RPS() float64 {
+	r.rateMutex.Lock()
+	defer r.rateMutex.Unlock()
 
-	r.RateLimiter = time.NewTicker(time.Microsecond * time.Duration(ratemicros))
-	r.rateCounter = ring.New(rate * 5)
+	return r.rps
+}
+
+func (r *RateThrottle) Burst() int {
+	r.rateMutex.Lock()
+	defer r.rateMutex.Unlock()
+
+	return r.burst
 }
 
 func (r *RateThrottle) Stop() {
-	r.RPS = 0
-	r.RateLimiter.Stop()
-	r.rateCounter = ring.New(0)
+	r.ChangeRate(Unlimited)
 }
 
-func (r *RateThrottle) SetRatelimitPercentage(percentage uint8) {
+// SetRatelimitPercentage reduces the rate by the given percentage,
+// it returns false if the percentage was already set.
+func (r *RateThrottle) SetRatelimitPercentage(percentage uint8) bool {
 	if percentage > 100 {
 		panic("Ratelimit percentage above 100 passed, that's a bug")
 	}
-	r.throttleRate = uint64(float64(r.RPS) / 100 * float64(percentage))
+
+	r.rateMutex.Lock()
+	defer r.rateMutex.Unlock()
+
+	if percentage == r.throttlePercentage {
+		return false
+	}
+
+	r.refill(time.Now())
+	r.throttlePercentage = percentage
+	r.notifyChange()
+
+	return true
 }
 
-func (r *RateThrottle) GetThrottleRate() uint64 {
-	return r.throttleRate
+func (r *RateThrottle) GetThrottleRate() float64 {
+	r.rateMutex.Lock()
+	defer r.rateMutex.Unlock()
+
+	return r.throttleRate()
 }
 
-// rateTick adds a new duration measurement Tick to rate counter
+// CurrentRate calculates the requests/second value over the last few seconds
+func (r *RateThrottle) CurrentRate() float64 {
+	r.rateMutex.Lock()
+	defer r.rateMutex.Unlock()
+
+	if r.firstTick.IsZero() {
+		return 0
+	}
+
+	now := time.Now()
+	current := now.UnixNano() / int64(bucketWidth)
+
+	count := int64(0)
+	for i := range r.buckets {
+		if r.bucketIdx[i] > current-bucketCount {
+			count += r.buckets[i]
+		}
+	}
+
+	elapsed := now.Sub(r.firstTick)
+	if elapsed > bucketWidth*bucketCount {
+		elapsed = bucketWidth * bucketCount
+	}
+	if elapsed < bucketWidth {
+		elapsed = bucketWidth
+	}
+
+	return float64(count) / elapsed.Seconds()
+}
+
+// Tick records a completed request
 func (r *RateThrottle) Tick(end time.Time) {
 	r.rateMutex.Lock()
 	defer r.rateMutex.Unlock()
-	
-	if r.RPS == 0 {
-		return
+
+	if r.firstTick.IsZero() {
+		r.firstTick = end
 	}
 
-	r.rateCounter = r.rateCounter.Next()
-	r.rateCounter.Value = end.UnixMicro()
+	idx := end.UnixNano() / int64(bucketWidth)
+	slot := idx % bucketCount
+	if r.bucketIdx[slot] != idx {
+		r.bucketIdx[slot] = idx
+		r.buckets[slot] = 0
+	}
+	r.buckets[slot]++
 }
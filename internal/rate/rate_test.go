@@ -0,0 +1,159 @@
+package rate
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReserveBurst(t *testing.T) {
+	r := NewRateThrottle(10, 3)
+	now := r.lastRefill
+
+	for i := 0; i < 3; i++ {
+		if delay := r.reserve(now); delay != 0 {
+			t.Fatalf("token %d: expected no delay within burst, got %s", i, delay)
+		}
+	}
+
+	if delay := r.reserve(now); delay != 100*time.Millisecond {
+		t.Fatalf("expected 100ms delay once the burst is spent, got %s", delay)
+	}
+}
+
+func TestReserveRefill(t *testing.T) {
+	r := NewRateThrottle(10, 1)
+	now := r.lastRefill
+
+	r.reserve(now)
+	if delay := r.reserve(now.Add(100 * time.Millisecond)); delay != 0 {
+		t.Fatalf("expected a token to be refilled after 100ms, got %s delay", delay)
+	}
+
+	// idle time never accumulates more tokens than the burst
+	r.reserve(now.Add(time.Hour))
+	if delay := r.reserve(now.Add(time.Hour)); delay == 0 {
+		t.Fatal("expected tokens to be capped at the burst")
+	}
+}
+
+func TestReserveUnlimited(t *testing.T) {
+	r := NewRateThrottle(Unlimited, 1)
+	now := time.Now()
+
+	for i := 0; i < 1000; i++ {
+		if delay := r.reserve(now); delay != 0 {
+			t.Fatalf("expected no delay without a rate limit, got %s", delay)
+		}
+	}
+}
+
+func TestThrottlePercentage(t *testing.T) {
+	r := NewRateThrottle(10, 1)
+	if !r.SetRatelimitPercentage(50) {
+		t.Fatal("expected the percentage to change")
+	}
+	if r.SetRatelimitPercentage(50) {
+		t.Fatal("expected setting the same percentage to report no change")
+	}
+	if got := r.GetThrottleRate(); got != 5 {
+		t.Fatalf("expected a throttle rate of 5, got %f", got)
+	}
+
+	now := r.lastRefill
+	r.reserve(now)
+	if delay := r.reserve(now); delay != 200*time.Millisecond {
+		t.Fatalf("expected 200ms delay at half the rate, got %s", delay)
+	}
+
+	// the rate never drops to zero
+	r.SetRatelimitPercentage(100)
+	if rate := r.effectiveRate(); rate <= 0 {
+		t.Fatalf("expected a positive effective rate, got %f", rate)
+	}
+}
+
+func TestThrottlePercentagePanicsAbove100(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic")
+		}
+	}()
+
+	NewRateThrottle(10, 1).SetRatelimitPercentage(101)
+}
+
+func TestWaitCancelReturnsToken(t *testing.T) {
+	r := NewRateThrottle(1, 1)
+	if err := r.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := r.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected the wait to be cancelled, got %v", err)
+	}
+
+	r.rateMutex.Lock()
+	tokens := r.tokens
+	r.rateMutex.Unlock()
+	if tokens < -0.1 {
+		t.Fatalf("expected the cancelled reservation to be given back, tokens: %f", tokens)
+	}
+}
+
+func TestChangeRateWakesWaiters(t *testing.T) {
+	r := NewRateThrottle(0.1, 1)
+	r.Wait(context.Background())
+
+	done := make(chan error)
+	go func() {
+		done <- r.Wait(context.Background())
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	r.ChangeRate(Unlimited)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the waiter to be released by the rate change")
+	}
+}
+
+func TestSetBurstCapsTokens(t *testing.T) {
+	r := NewRateThrottle(10, 5)
+	r.SetBurst(2)
+
+	if got := r.Burst(); got != 2 {
+		t.Fatalf("expected burst 2, got %d", got)
+	}
+
+	now := r.lastRefill
+	r.reserve(now)
+	r.reserve(now)
+	if delay := r.reserve(now); delay == 0 {
+		t.Fatal("expected tokens above the new burst to be dropped")
+	}
+}
+
+func TestCurrentRate(t *testing.T) {
+	r := NewRateThrottle(Unlimited, 1)
+	if got := r.CurrentRate(); got != 0 {
+		t.Fatalf("expected no rate before any tick, got %f", got)
+	}
+
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		r.Tick(now)
+	}
+
+	// ticks within the first bucket are measured over one bucket width
+	if got := r.CurrentRate(); got <= 0 || got > 100 {
+		t.Fatalf("expected a rate of at most 100/s, got %f", got)
+	}
+}
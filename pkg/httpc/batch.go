@@ -0,0 +1,98 @@
+package httpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+const defaultBatchWindow = 100
+
+// ErrNoRequest is the error of batch requests that have neither a Request nor a RawRequest.
+var ErrNoRequest = errors.New("batch request has neither a Request nor a RawRequest")
+
+// BatchRequest is a request submitted through SendBatch, Key is returned
+// unchanged with its result so that callers can correlate the two.
+type BatchRequest struct {
+	Key any
+
+	Request *http.Request
+
+	// RawRequest is sent instead of Request when set
+	RawRequest string
+	BaseUrl    string
+}
+
+// BatchResult is the final message of a batch request, requests that couldn't be sent
+// fail with an UnknownError transport error and the cause as the message's Error.
+type BatchResult struct {
+	Key     any
+	Message *MessageDuplex
+}
+
+func (c *HttpClient) SendBatch(requests <-chan BatchRequest, opts ClientOptions) <-chan BatchResult {
+	return c.SendBatchContext(context.Background(), requests, opts)
+}
+
+// SendBatchContext reads requests until the channel is closed and returns a channel of results
+// in completion order, which is closed once every result has been delivered. At most
+// Performance.BatchWindow requests are queued or in flight at a time, so requests are only
+// read as fast as the client can send them and the results are consumed.
+func (c *HttpClient) SendBatchContext(ctx context.Context, requests <-chan BatchRequest, opts ClientOptions) <-chan BatchResult {
+	window := opts.Performance.BatchWindow
+	if window <= 0 {
+		window = defaultBatchWindow
+	}
+
+	results := make(chan BatchResult, window)
+	slots := make(chan struct{}, window)
+
+	go func() {
+		var wg sync.WaitGroup
+		defer func() {
+			wg.Wait()
+			close(results)
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case slots <- struct{}{}:
+			}
+
+			var item BatchRequest
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return
+			case item, ok = <-requests:
+				if !ok {
+					return
+				}
+			}
+
+			var future *Future
+			switch {
+			case item.RawRequest != "":
+				future = c.SendRawWithOptionsContext(ctx, item.RawRequest, item.BaseUrl, opts)
+			case item.Request != nil:
+				future = c.SendWithOptionsContext(ctx, item.Request, opts)
+			default:
+				future = newFuture()
+				future.complete(&MessageDuplex{TransportError: UnknownError, Error: ErrNoRequest})
+			}
+
+			wg.Add(1)
+			future.OnComplete(func(msg *MessageDuplex) {
+				defer wg.Done()
+
+				results <- BatchResult{Key: item.Key, Message: msg}
+				<-slots
+			})
+		}
+	}()
+
+	return results
+}
@@ -0,0 +1,165 @@
+package httpc_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aristosMiliaressis/httpc/pkg/httpc"
+	"github.com/aristosMiliaressis/httpc/pkg/httpctest"
+)
+
+// collect reads every result until the channel is closed, failing the test if it isn't closed in time.
+func collect(t *testing.T, results <-chan httpc.BatchResult) []httpc.BatchResult {
+	t.Helper()
+
+	collected := []httpc.BatchResult{}
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				return collected
+			}
+			collected = append(collected, result)
+		case <-timeout:
+			t.Fatalf("expected the results channel to be closed, got %d results", len(collected))
+		}
+	}
+}
+
+func TestSendBatchKeys(t *testing.T) {
+	srv := httpctest.NewServer()
+	defer srv.Close()
+
+	c := httpctest.NewClient(t, nil)
+
+	requests := make(chan httpc.BatchRequest)
+	go func() {
+		defer close(requests)
+		for i := 0; i < 20; i++ {
+			req, _ := http.NewRequest("GET", fmt.Sprintf("%s/%d", srv.URL, i), nil)
+			requests <- httpc.BatchRequest{Key: i, Request: req}
+		}
+		requests <- httpc.BatchRequest{Key: "raw", RawRequest: "GET /raw HTTP/1.1\r\nHost: a.test\r\n\r\n", BaseUrl: srv.URL}
+		requests <- httpc.BatchRequest{Key: "empty"}
+	}()
+
+	results := collect(t, c.SendBatch(requests, c.Options))
+	if len(results) != 22 {
+		t.Fatalf("expected 22 results, got %d", len(results))
+	}
+
+	for _, result := range results {
+		switch key := result.Key.(type) {
+		case int:
+			if want := fmt.Sprintf("/%d", key); result.Message.Request.URL.Path != want {
+				t.Fatalf("expected the result for key %d to be for %s, got %s", key, want, result.Message.Request.URL.Path)
+			}
+		case string:
+			if key == "raw" && result.Message.Response == nil {
+				t.Fatalf("expected the raw request to be sent, got %s", result.Message.TransportError)
+			}
+			if key == "empty" && (result.Message.TransportError != httpc.UnknownError || !errors.Is(result.Message.Error, httpc.ErrNoRequest)) {
+				t.Fatalf("expected a request without Request or RawRequest to fail, got %s", result.Message.TransportError)
+			}
+		}
+	}
+	httpctest.AssertServerRequests(t, srv, 21)
+}
+
+func TestSendBatchWindow(t *testing.T) {
+	const window = 3
+
+	var current, peak atomic.Int64
+	srv := httpctest.NewServer().Script(httpctest.Rule{Behavior: func(w http.ResponseWriter, r *http.Request) {
+		n := current.Add(1)
+		defer current.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(10 * time.Millisecond)
+	}})
+	defer srv.Close()
+
+	c := httpctest.NewClient(t, nil)
+	opts := c.Options
+	opts.Performance.BatchWindow = window
+
+	var read atomic.Int64
+	requests := make(chan httpc.BatchRequest)
+	go func() {
+		defer close(requests)
+		for i := 0; i < 20; i++ {
+			req, _ := http.NewRequest("GET", srv.URL, nil)
+			requests <- httpc.BatchRequest{Key: i, Request: req}
+			read.Add(1)
+		}
+	}()
+
+	results := c.SendBatch(requests, opts)
+
+	// without consuming results at most window results are buffered and window requests are in flight
+	time.Sleep(200 * time.Millisecond)
+	if n := read.Load(); n > 2*window+1 {
+		t.Fatalf("expected requests to be read as results are consumed, %d were read", n)
+	}
+
+	if got := len(collect(t, results)); got != 20 {
+		t.Fatalf("expected 20 results, got %d", got)
+	}
+	if p := peak.Load(); p > window {
+		t.Fatalf("expected at most %d requests in flight, peak was %d", window, p)
+	}
+}
+
+func TestSendBatchCancelled(t *testing.T) {
+	srv := httpctest.NewServer().Script(httpctest.Rule{Behavior: httpctest.Stall(5 * time.Second)})
+	defer srv.Close()
+
+	c := httpctest.NewClient(t, nil)
+	opts := c.Options
+	opts.Performance.BatchWindow = 2
+
+	// the input channel is never closed, cancelling the context ends the batch
+	requests := make(chan httpc.BatchRequest)
+	go func() {
+		for i := 0; ; i++ {
+			req, _ := http.NewRequest("GET", srv.URL, nil)
+			select {
+			case requests <- httpc.BatchRequest{Key: i, Request: req}:
+			case <-time.After(5 * time.Second):
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := c.SendBatchContext(ctx, requests, opts)
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	collected := collect(t, results)
+	if len(collected) != 2 {
+		t.Fatalf("expected the results of the 2 requests in flight, got %d", len(collected))
+	}
+	for _, result := range collected {
+		if result.Message.TransportError != httpc.Cancelled {
+			t.Fatalf("expected in-flight requests to be cancelled, got %s", result.Message.TransportError)
+		}
+	}
+}
+
+func TestSendBatchEmpty(t *testing.T) {
+	c := httpctest.NewClient(t, nil)
+
+	requests := make(chan httpc.BatchRequest)
+	close(requests)
+
+	if got := len(collect(t, c.SendBatch(requests, c.Options))); got != 0 {
+		t.Fatalf("expected no results, got %d", got)
+	}
+}
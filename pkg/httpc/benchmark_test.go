@@ -0,0 +1,132 @@
+package httpc_test
+
+import (
+	"net/http"
+	"runtime/metrics"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aristosMiliaressis/httpc/pkg/httpc"
+	"github.com/aristosMiliaressis/httpc/pkg/httpctest"
+)
+
+// inFlight responds after the given latency and keeps track of the peak number of concurrent requests.
+type inFlight struct {
+	current, peak atomic.Int64
+}
+
+func (f *inFlight) behavior(latency time.Duration) httpctest.Behavior {
+	return func(w http.ResponseWriter, r *http.Request) {
+		current := f.current.Add(1)
+		defer f.current.Add(-1)
+		for peak := f.peak.Load(); current > peak && !f.peak.CompareAndSwap(peak, current); peak = f.peak.Load() {
+		}
+
+		time.Sleep(latency)
+		w.Write([]byte("ok"))
+	}
+}
+
+func newBenchmarkClient(b *testing.B, rps float64, concurrency int) *httpc.HttpClient {
+	return httpctest.NewClient(b, func(opts *httpc.ClientOptions) {
+		opts.Connection.DisableKeepAlives = false
+		opts.Performance.RequestsPerSecond = rps
+		opts.Performance.MaxConcurrency = concurrency
+	})
+}
+
+// sendAll sends b.N requests at once and waits for every one of them.
+func sendAll(b *testing.B, c *httpc.HttpClient, url string) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	futures := make([]*httpc.Future, b.N)
+	for i := range futures {
+		futures[i] = c.Send(req)
+	}
+	for _, future := range futures {
+		<-future.Done()
+	}
+}
+
+func busyCpuSeconds() float64 {
+	samples := []metrics.Sample{
+		{Name: "/cpu/classes/total:cpu-seconds"},
+		{Name: "/cpu/classes/idle:cpu-seconds"},
+	}
+	metrics.Read(samples)
+
+	return samples[0].Value.Float64() - samples[1].Value.Float64()
+}
+
+// BenchmarkSend measures dispatch throughput without rate or concurrency limits.
+func BenchmarkSend(b *testing.B) {
+	var flight inFlight
+	srv := httpctest.NewServer().Script(httpctest.Rule{Behavior: flight.behavior(0)})
+	defer srv.Close()
+
+	c := newBenchmarkClient(b, httpc.UnlimitedRate, 0)
+
+	b.ResetTimer()
+	sendAll(b, c, srv.URL)
+	b.StopTimer()
+
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "req/s")
+	b.ReportMetric(float64(flight.peak.Load()), "peak-in-flight")
+}
+
+// BenchmarkSendRateLimited measures how closely the token bucket holds the target rate.
+func BenchmarkSendRateLimited(b *testing.B) {
+	const rps = 1000
+
+	srv := httpctest.NewServer()
+	defer srv.Close()
+
+	c := newBenchmarkClient(b, rps, 0)
+
+	b.ResetTimer()
+	sendAll(b, c, srv.URL)
+	b.StopTimer()
+
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "req/s")
+}
+
+// BenchmarkSendConcurrencyLimited measures throughput against a slow server with few requests in flight.
+func BenchmarkSendConcurrencyLimited(b *testing.B) {
+	const concurrency = 8
+
+	var flight inFlight
+	srv := httpctest.NewServer().Script(httpctest.Rule{Behavior: flight.behavior(time.Millisecond)})
+	defer srv.Close()
+
+	c := newBenchmarkClient(b, httpc.UnlimitedRate, concurrency)
+
+	b.ResetTimer()
+	sendAll(b, c, srv.URL)
+	b.StopTimer()
+
+	if peak := flight.peak.Load(); peak > concurrency {
+		b.Fatalf("expected at most %d requests in flight, got %d", concurrency, peak)
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "req/s")
+	b.ReportMetric(float64(flight.peak.Load()), "peak-in-flight")
+}
+
+// BenchmarkIdle measures the cpu used by a client with nothing to send, the dispatcher shouldn't poll.
+func BenchmarkIdle(b *testing.B) {
+	const period = 10 * time.Millisecond
+
+	newBenchmarkClient(b, 1000, 0)
+
+	before := busyCpuSeconds()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		time.Sleep(period)
+	}
+	b.StopTimer()
+
+	b.ReportMetric((busyCpuSeconds()-before)/b.Elapsed().Seconds()*100, "%cpu")
+}
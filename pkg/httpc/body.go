@@ -0,0 +1,322 @@
+package httpc
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Body is a stored message body that can be read any number of times,
+// bodies larger than MaxBodyMemory are kept in a file instead of memory.
+type Body struct {
+	data []byte
+	file string
+	size int64
+	// refs counts the messages using a body kept in a file, the file is removed when none are left
+	refs atomic.Int64
+}
+
+func NewBody(data []byte) *Body {
+	return &Body{data: data, size: int64(len(data))}
+}
+
+// Len returns the size of the body, zero for a nil body.
+func (b *Body) Len() int64 {
+	if b == nil {
+		return 0
+	}
+
+	return b.size
+}
+
+// InFile returns whether the body is kept in a file.
+func (b *Body) InFile() bool {
+	return b != nil && b.file != ""
+}
+
+// Reader returns a new reader positioned at the start of the body.
+func (b *Body) Reader() io.ReadCloser {
+	if b == nil || b.size == 0 {
+		return http.NoBody
+	}
+
+	if b.file == "" {
+		return &bodyReader{Reader: bytes.NewReader(b.data), body: b}
+	}
+
+	file, err := os.Open(b.file)
+	if err != nil {
+		return &bodyReader{Reader: &errReader{err}, body: b}
+	}
+
+	return &bodyReader{Reader: file, Closer: file, body: b}
+}
+
+// Bytes returns the whole body, reading it from its file if needed.
+func (b *Body) Bytes() ([]byte, error) {
+	if b == nil {
+		return nil, nil
+	}
+
+	if b.file == "" {
+		return b.data, nil
+	}
+
+	return os.ReadFile(b.file)
+}
+
+func (b *Body) String() string {
+	data, _ := b.Bytes()
+	return string(data)
+}
+
+// getBody can be used as http.Request.GetBody.
+func (b *Body) getBody() (io.ReadCloser, error) {
+	return b.Reader(), nil
+}
+
+// bodyReader keeps a reference to the body it reads from,
+// so requests built from a stored body can share it instead of copying it.
+type bodyReader struct {
+	io.Reader
+	io.Closer
+	body *Body
+}
+
+func (r *bodyReader) Close() error {
+	if r.Closer == nil {
+		return nil
+	}
+
+	return r.Closer.Close()
+}
+
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+// readBody reads and closes a body into memory.
+func readBody(rc io.ReadCloser) *Body {
+	if rc == nil || rc == http.NoBody {
+		return nil
+	}
+	defer rc.Close()
+
+	data, _ := io.ReadAll(rc)
+
+	return NewBody(data)
+}
+
+// bodyStore stores the bodies of a message store's messages, spilling large ones to files that are removed
+// with the last message using them or when the store is closed.
+type bodyStore struct {
+	mutex sync.Mutex
+	opts  MessageLogOptions
+	dir   string
+}
+
+// store reads r into a Body, reading stops at the first error and what was read up to then is kept.
+func (s *bodyStore) store(r io.Reader) (*Body, error) {
+	if s.opts.MaxBodyMemory <= 0 {
+		data, err := io.ReadAll(r)
+		return NewBody(data), err
+	}
+
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, r, s.opts.MaxBodyMemory+1)
+	if n <= s.opts.MaxBodyMemory {
+		if err == io.EOF {
+			err = nil
+		}
+		return NewBody(buf.Bytes()), err
+	}
+
+	file, createErr := s.createFile()
+	if createErr != nil {
+		rest, err := io.ReadAll(r)
+		return NewBody(append(buf.Bytes(), rest...)), err
+	}
+	defer file.Close()
+
+	written, err := io.Copy(file, io.MultiReader(&buf, r))
+
+	body := &Body{file: file.Name(), size: written}
+	body.refs.Store(1)
+
+	return body, err
+}
+
+func (s *bodyStore) createFile() (*os.File, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.dir == "" {
+		dir, err := os.MkdirTemp(s.opts.SpillDir, "httpc-bodies-*")
+		if err != nil {
+			return nil, err
+		}
+		s.dir = dir
+	}
+
+	return os.CreateTemp(s.dir, "body-*")
+}
+
+// retain adds a message using the body.
+func (b *Body) retain() {
+	if b.InFile() {
+		b.refs.Add(1)
+	}
+}
+
+// release removes a message using the body, removing its file if it was the last one.
+func (b *Body) release() {
+	if b.InFile() && b.refs.Add(-1) == 0 {
+		os.Remove(b.file)
+	}
+}
+
+// close removes the files of the stored bodies.
+func (s *bodyStore) close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.dir == "" {
+		return nil
+	}
+
+	err := os.RemoveAll(s.dir)
+	s.dir = ""
+
+	return err
+}
+
+// storeRequestBody stores the body of the message's request and makes the request read from it,
+// requests whose body is already stored, e.g. retries and redirects, share it.
+func (e *MessageDuplex) storeRequestBody(store *bodyStore) error {
+	req := e.Request
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+
+	body := req.Body
+	if req.GetBody != nil {
+		if rc, err := req.GetBody(); err == nil {
+			body = rc
+		}
+	}
+
+	defer body.Close()
+
+	if stored, ok := body.(*bodyReader); ok {
+		stored.body.retain()
+		e.setRequestBody(stored.body)
+		return nil
+	}
+
+	stored, err := store.store(body)
+	e.setRequestBody(stored)
+
+	return err
+}
+
+func (e *MessageDuplex) setRequestBody(body *Body) {
+	e.RequestBody = body
+	if e.Request == nil || body == nil {
+		return
+	}
+
+	e.Request.Body = body.Reader()
+	e.Request.GetBody = body.getBody
+	e.Request.ContentLength = body.Len()
+}
+
+func (e *MessageDuplex) setResponseBody(body *Body) {
+	e.ResponseBody = body
+	if e.Response == nil {
+		return
+	}
+
+	e.Response.Body = body.Reader()
+}
+
+// responseBytes returns the response body, storing it first for messages built from a bare response.
+func (e *MessageDuplex) responseBytes() ([]byte, error) {
+	if e.Response == nil {
+		return nil, nil
+	}
+
+	if e.ResponseBody == nil && e.Response.Body != nil {
+		e.setResponseBody(readBody(e.Response.Body))
+	}
+
+	return e.ResponseBody.Bytes()
+}
+
+// requestBytes returns the request body.
+func (e *MessageDuplex) requestBytes() ([]byte, error) {
+	if e.RequestBody != nil {
+		return e.RequestBody.Bytes()
+	}
+
+	if e.Request == nil || e.Request.GetBody == nil {
+		return nil, nil
+	}
+
+	rc, err := e.Request.GetBody()
+	if err != nil {
+		return nil, err
+	}
+
+	return readBody(rc).Bytes()
+}
+
+// dumpRequest dumps the request including its body, read through GetBody when available
+// since the body of a sent request has already been consumed.
+func dumpRequest(req *http.Request) ([]byte, error) {
+	clone := req.Clone(req.Context())
+	// client requests carry their length in ContentLength rather than a header,
+	// without it the body can't be read back from the dump
+	if clone.ContentLength > 0 && clone.Header.Get("Content-Length") == "" && len(clone.TransferEncoding) == 0 {
+		clone.Header.Set("Content-Length", strconv.FormatInt(clone.ContentLength, 10))
+	}
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		clone.Body = body
+		return httputil.DumpRequest(clone, true)
+	}
+
+	dump, err := httputil.DumpRequest(clone, req.Body != nil)
+	// DumpRequest consumed the shared body and left a copy on the clone
+	req.Body = clone.Body
+
+	return dump, err
+}
+
+// dumpResponse dumps the response including its stored body.
+func dumpResponse(msg *MessageDuplex) ([]byte, error) {
+	body, err := msg.responseBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := *msg.Response
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if resp.ContentLength >= 0 {
+		resp.ContentLength = int64(len(body))
+	}
+
+	return httputil.DumpResponse(&resp, true)
+}
@@ -0,0 +1,349 @@
+package httpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const burpTimeFormat = "Mon Jan 02 15:04:05 MST 2006"
+
+const burpXmlHeader = `<?xml version="1.0"?>
+<!DOCTYPE items [
+<!ELEMENT items (item*)>
+<!ATTLIST items burpVersion CDATA "">
+<!ATTLIST items exportTime CDATA "">
+<!ELEMENT item (time, url, host, port, protocol, method, path, extension, request, status, responselength, mimetype, response, comment)>
+<!ELEMENT time (#PCDATA)>
+<!ELEMENT url (#PCDATA)>
+<!ELEMENT host (#PCDATA)>
+<!ATTLIST host ip CDATA "">
+<!ELEMENT port (#PCDATA)>
+<!ELEMENT protocol (#PCDATA)>
+<!ELEMENT method (#PCDATA)>
+<!ELEMENT path (#PCDATA)>
+<!ELEMENT extension (#PCDATA)>
+<!ELEMENT request (#PCDATA)>
+<!ATTLIST request base64 (true|false) "false">
+<!ELEMENT status (#PCDATA)>
+<!ELEMENT responselength (#PCDATA)>
+<!ELEMENT mimetype (#PCDATA)>
+<!ELEMENT response (#PCDATA)>
+<!ATTLIST response base64 (true|false) "false">
+<!ELEMENT comment (#PCDATA)>
+]>
+`
+
+// BurpItem is an entry of a Burp Suite "save items" XML file,
+// Request can be sent as is with SendRaw using Url as the base url.
+type BurpItem struct {
+	Time     time.Time
+	Url      string
+	Host     string
+	Ip       string
+	Port     int
+	Protocol string
+	Method   string
+	Path     string
+	Request  []byte
+	Status   int
+	MimeType string
+	Response []byte
+	Comment  string
+}
+
+type burpItems struct {
+	XMLName     xml.Name      `xml:"items"`
+	BurpVersion string        `xml:"burpVersion,attr"`
+	ExportTime  string        `xml:"exportTime,attr"`
+	Items       []burpXmlItem `xml:"item"`
+}
+
+type burpXmlItem struct {
+	Time           string       `xml:"time"`
+	Url            burpCData    `xml:"url"`
+	Host           burpHost     `xml:"host"`
+	Port           int          `xml:"port"`
+	Protocol       string       `xml:"protocol"`
+	Method         burpCData    `xml:"method"`
+	Path           burpCData    `xml:"path"`
+	Extension      string       `xml:"extension"`
+	Request        burpEncoded  `xml:"request"`
+	Status         string       `xml:"status"`
+	ResponseLength int          `xml:"responselength"`
+	MimeType       string       `xml:"mimetype"`
+	Response       *burpEncoded `xml:"response"`
+	Comment        string       `xml:"comment"`
+}
+
+type burpCData struct {
+	Value string `xml:",cdata"`
+}
+
+type burpHost struct {
+	Ip    string `xml:"ip,attr"`
+	Value string `xml:",chardata"`
+}
+
+type burpEncoded struct {
+	Base64 bool   `xml:"base64,attr"`
+	Value  string `xml:",cdata"`
+}
+
+func (e burpEncoded) decode() ([]byte, error) {
+	if !e.Base64 {
+		return []byte(e.Value), nil
+	}
+
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(e.Value))
+}
+
+// ReadBurpXml loads the items of a Burp Suite "save items" XML file.
+func ReadBurpXml(r io.Reader) ([]BurpItem, error) {
+	var file burpItems
+	if err := xml.NewDecoder(r).Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to decode burp xml: %w", err)
+	}
+
+	items := []BurpItem{}
+	for i, xmlItem := range file.Items {
+		item := BurpItem{
+			Url:      xmlItem.Url.Value,
+			Host:     xmlItem.Host.Value,
+			Ip:       xmlItem.Host.Ip,
+			Port:     xmlItem.Port,
+			Protocol: xmlItem.Protocol,
+			Method:   xmlItem.Method.Value,
+			Path:     xmlItem.Path.Value,
+			MimeType: xmlItem.MimeType,
+			Comment:  xmlItem.Comment,
+		}
+		item.Time, _ = time.Parse(burpTimeFormat, xmlItem.Time)
+		item.Status, _ = strconv.Atoi(xmlItem.Status)
+
+		var err error
+		if item.Request, err = xmlItem.Request.decode(); err != nil {
+			return nil, fmt.Errorf("failed to decode request of burp item %d: %w", i, err)
+		}
+		if xmlItem.Response != nil {
+			if item.Response, err = xmlItem.Response.decode(); err != nil {
+				return nil, fmt.Errorf("failed to decode response of burp item %d: %w", i, err)
+			}
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// HttpRequest parses the raw request of the item for use with Send.
+func (item BurpItem) HttpRequest() (*http.Request, error) {
+	return ParseRawRequest(item.Request, item.Url)
+}
+
+// Message converts the item to a message, the response is omitted if the item has none.
+func (item BurpItem) Message() (*MessageDuplex, error) {
+	req, err := item.HttpRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &MessageDuplex{Request: req, Timestamp: item.Time}
+	if len(item.Response) == 0 {
+		return msg, nil
+	}
+
+	msg.Response, err = http.ReadResponse(bufio.NewReader(bytes.NewReader(item.Response)), req)
+	if err != nil {
+		return nil, err
+	}
+	msg.setResponseBody(readBody(msg.Response.Body))
+
+	return msg, nil
+}
+
+var rawRequestLineRegex = regexp.MustCompile(`^(\S+ \S+ )HTTP/(2|3)(\.0)?$`)
+
+// ParseRawRequest parses a raw http request like the ones saved from Burp Suite,
+// the scheme and host are taken from the target url while the path is taken from the request line.
+// Line endings are normalized and Content-Length is recalculated so edited requests are accepted.
+func ParseRawRequest(raw []byte, target string) (*http.Request, error) {
+	raw = bytes.TrimLeft(raw, "\r\n")
+
+	head, body, found := bytes.Cut(raw, []byte("\r\n\r\n"))
+	if !found {
+		head, body, _ = bytes.Cut(raw, []byte("\n\n"))
+	}
+
+	lines := strings.Split(strings.ReplaceAll(string(head), "\r\n", "\n"), "\n")
+	// http.ReadRequest only understands HTTP/1.x request lines
+	lines[0] = rawRequestLineRegex.ReplaceAllString(strings.TrimSpace(lines[0]), "${1}HTTP/1.1")
+
+	headers := []string{}
+	for _, line := range lines {
+		if !strings.HasPrefix(strings.ToLower(line), "content-length:") {
+			headers = append(headers, line)
+		}
+	}
+	if len(body) > 0 {
+		headers = append(headers, fmt.Sprintf("Content-Length: %d", len(body)))
+	}
+
+	req, err := http.ReadRequest(bufio.NewReader(io.MultiReader(
+		strings.NewReader(strings.Join(headers, "\r\n")+"\r\n\r\n"),
+		bytes.NewReader(body))))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse raw request: %w", err)
+	}
+	req.RequestURI = ""
+
+	if target == "" {
+		target = "https://" + req.Host
+	}
+	targetUrl, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target url %q: %w", target, err)
+	}
+	req.URL.Scheme = targetUrl.Scheme
+	req.URL.Host = targetUrl.Host
+	if req.Host == "" {
+		req.Host = targetUrl.Host
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	if len(body) == 0 {
+		req.Body = http.NoBody
+	}
+
+	return req, nil
+}
+
+// ReadRawRequest reads a raw http request file, see ParseRawRequest.
+func ReadRawRequest(r io.Reader, target string) (*http.Request, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseRawRequest(raw, target)
+}
+
+// ExportBurpXml writes the messages in Burp Suite's "save items" XML format
+// with base64 encoded requests and responses.
+func (log MessageLog) ExportBurpXml(w io.Writer) error {
+	file := burpItems{ExportTime: time.Now().Format(burpTimeFormat), Items: []burpXmlItem{}}
+	for _, msg := range log {
+		if msg.Request == nil {
+			continue
+		}
+		item, err := newBurpXmlItem(msg)
+		if err != nil {
+			return err
+		}
+		file.Items = append(file.Items, item)
+	}
+
+	if _, err := io.WriteString(w, burpXmlHeader); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(file); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// ExportBurpXml writes every message in the store in Burp Suite's "save items" XML format.
+func (s *MessageStore) ExportBurpXml(w io.Writer) error {
+	return s.Messages().ExportBurpXml(w)
+}
+
+func newBurpXmlItem(msg *MessageDuplex) (burpXmlItem, error) {
+	reqUrl := msg.Request.URL
+
+	req, err := dumpRequest(msg.Request)
+	if err != nil {
+		return burpXmlItem{}, err
+	}
+
+	port, _ := strconv.Atoi(reqUrl.Port())
+	if port == 0 && reqUrl.Scheme == "http" {
+		port = 80
+	} else if port == 0 {
+		port = 443
+	}
+
+	extension := "null"
+	if idx := strings.LastIndex(reqUrl.Path, "."); idx != -1 && !strings.Contains(reqUrl.Path[idx:], "/") {
+		extension = reqUrl.Path[idx+1:]
+	}
+
+	item := burpXmlItem{
+		Time:      msg.Timestamp.Format(burpTimeFormat),
+		Url:       burpCData{Value: reqUrl.String()},
+		Host:      burpHost{Value: reqUrl.Hostname()},
+		Port:      port,
+		Protocol:  reqUrl.Scheme,
+		Method:    burpCData{Value: msg.Request.Method},
+		Path:      burpCData{Value: reqUrl.RequestURI()},
+		Extension: extension,
+		Request:   burpEncoded{Base64: true, Value: base64.StdEncoding.EncodeToString(req)},
+	}
+	if msg.Timestamp.IsZero() {
+		item.Time = time.Now().Format(burpTimeFormat)
+	}
+
+	if msg.Response != nil {
+		resp, err := dumpResponse(msg)
+		if err != nil {
+			return burpXmlItem{}, err
+		}
+		item.Status = strconv.Itoa(msg.Response.StatusCode)
+		item.ResponseLength = len(resp)
+		item.MimeType = burpMimeType(msg.Response.Header.Get("Content-Type"))
+		item.Response = &burpEncoded{Base64: true, Value: base64.StdEncoding.EncodeToString(resp)}
+	}
+
+	return item, nil
+}
+
+func burpMimeType(contentType string) string {
+	contentType = strings.ToLower(contentType)
+	switch {
+	case contentType == "":
+		return ""
+	case strings.Contains(contentType, "html"):
+		return "HTML"
+	case strings.Contains(contentType, "json"):
+		return "JSON"
+	case strings.Contains(contentType, "javascript"), strings.Contains(contentType, "ecmascript"):
+		return "script"
+	case strings.Contains(contentType, "css"):
+		return "CSS"
+	case strings.Contains(contentType, "xml"):
+		return "XML"
+	case strings.Contains(contentType, "image/"):
+		return strings.ToUpper(strings.TrimPrefix(strings.Split(contentType, ";")[0], "image/"))
+	case strings.HasPrefix(contentType, "text/"):
+		return "text"
+	default:
+		return "app"
+	}
+}
@@ -0,0 +1,104 @@
+package httpc_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aristosMiliaressis/httpc/pkg/httpc"
+	"github.com/aristosMiliaressis/httpc/pkg/httpctest"
+)
+
+func TestBurpXmlRoundTrip(t *testing.T) {
+	srv := httpctest.NewServer().Script(
+		httpctest.Rule{Path: "/page.html", Behavior: httpctest.Respond(200, http.Header{"Content-Type": {"text/html"}}, "<p>hi</p>")},
+		httpctest.Rule{Path: "/missing", Behavior: httpctest.Status(404)},
+	)
+	defer srv.Close()
+
+	c := httpctest.NewClient(t, nil)
+	httpctest.Send(t, c, "GET", srv.URL+"/page.html", "")
+	httpctest.Send(t, c, "POST", srv.URL+"/missing", `{"a":1}`)
+
+	var buf bytes.Buffer
+	if err := c.MessageLog.ExportBurpXml(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := httpc.ReadBurpXml(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("expected 2 items, got %d", len(items))
+	}
+
+	page := items[0]
+	if page.Url != srv.URL+"/page.html" || page.Method != "GET" || page.Status != 200 || page.Path != "/page.html" {
+		t.Fatalf("unexpected item %s %s %d %s", page.Method, page.Url, page.Status, page.Path)
+	}
+	msg, err := page.Message()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ResponseBody.String() != "<p>hi</p>" {
+		t.Fatalf("expected the response body to survive the round trip, got %q", msg.ResponseBody.String())
+	}
+
+	post := items[1]
+	if post.Status != 404 {
+		t.Fatalf("expected status 404, got %d", post.Status)
+	}
+	req, err := post.HttpRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(req.Body); string(body) != `{"a":1}` || req.Method != "POST" {
+		t.Fatalf("expected the POST body to survive the round trip, got %s %q", req.Method, body)
+	}
+
+	// items can be sent again as raw requests
+	srv.Reset()
+	replayed, err := c.SendRaw(string(post.Request), post.Url).Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed.Response == nil || replayed.Response.StatusCode != 200 {
+		t.Fatalf("expected the replayed request to reach the server, got %s", replayed.TransportError)
+	}
+	httpctest.AssertServerRequests(t, srv, 1)
+}
+
+func TestParseRawRequest(t *testing.T) {
+	// LF line endings, an HTTP/2 request line and a stale Content-Length as saved from an edited request
+	raw := "POST /api?x=1 HTTP/2\nHost: example.com\nContent-Type: text/plain\nContent-Length: 999\n\nedited body"
+
+	req, err := httpc.ParseRawRequest([]byte(raw), "http://127.0.0.1:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req.URL.String() != "http://127.0.0.1:8080/api?x=1" || req.Host != "example.com" {
+		t.Fatalf("expected the target's scheme and host with the request's path, got %s (host %s)", req.URL, req.Host)
+	}
+	if req.ContentLength != int64(len("edited body")) {
+		t.Fatalf("expected Content-Length to be recalculated, got %d", req.ContentLength)
+	}
+	for i := 0; i < 2; i++ {
+		body, _ := req.GetBody()
+		if data, _ := io.ReadAll(body); string(data) != "edited body" {
+			t.Fatalf("expected the body to be readable repeatedly, got %q", data)
+		}
+	}
+
+	req, err = httpc.ReadRawRequest(strings.NewReader("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.URL.String() != "https://example.com/" || req.Body != http.NoBody {
+		t.Fatalf("expected an https url from the Host header without a body, got %s", req.URL)
+	}
+}
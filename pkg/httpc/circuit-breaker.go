@@ -0,0 +1,236 @@
+package httpc
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/projectdiscovery/gologger"
+)
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	return []string{"Closed", "Open", "HalfOpen"}[s]
+}
+
+// ErrCircuitOpen is matched by the error of requests failed by an open circuit.
+var ErrCircuitOpen = errors.New("circuit open")
+
+type CircuitOpenError struct {
+	Host  string
+	Until time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit of %s open until %s", e.Host, e.Until.Format(time.TimeOnly))
+}
+
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// circuitBreaker keeps a circuit per host, a circuit opens after too many consecutive
+// transport failures and lets a few probe requests through once the cooldown is over.
+type circuitBreaker struct {
+	mutex    sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	state     BreakerState
+	failures  int
+	openUntil time.Time
+	probes    int
+	succeeded int
+}
+
+func newCircuitBreaker() *circuitBreaker {
+	return &circuitBreaker{circuits: map[string]*circuit{}}
+}
+
+// rejects returns an error if requests to the host must fail fast without being queued.
+func (b *circuitBreaker) rejects(host string, now time.Time) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	cb, ok := b.circuits[host]
+	if !ok || cb.state != BreakerOpen || !now.Before(cb.openUntil) {
+		return nil
+	}
+
+	return &CircuitOpenError{Host: host, Until: cb.openUntil}
+}
+
+// acquire admits a request to the host, moving an open circuit to half-open once the
+// cooldown is over, it returns an error if the request must fail fast.
+func (b *circuitBreaker) acquire(host string, now time.Time, opts CircuitBreakerOptions) (BreakerState, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	cb, ok := b.circuits[host]
+	if !ok {
+		return BreakerClosed, nil
+	}
+
+	if cb.state == BreakerOpen {
+		if now.Before(cb.openUntil) {
+			return cb.state, &CircuitOpenError{Host: host, Until: cb.openUntil}
+		}
+		cb.state = BreakerHalfOpen
+		cb.probes = 0
+		cb.succeeded = 0
+	}
+
+	if cb.state == BreakerHalfOpen {
+		if cb.probes >= max(opts.Probes, 1) {
+			// openUntil has already passed, while the probes are pending the host is retried after another cooldown at the earliest
+			return cb.state, &CircuitOpenError{Host: host, Until: now.Add(opts.Cooldown)}
+		}
+		cb.probes++
+	}
+
+	return cb.state, nil
+}
+
+// report records the outcome of an admitted request, it returns the new state
+// and whether the state changed.
+func (b *circuitBreaker) report(host string, failed bool, now time.Time, opts CircuitBreakerOptions) (BreakerState, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	cb, ok := b.circuits[host]
+	if !ok {
+		if !failed {
+			return BreakerClosed, false
+		}
+		cb = &circuit{}
+		b.circuits[host] = cb
+	}
+
+	switch {
+	case cb.state == BreakerHalfOpen && failed:
+		cb.state = BreakerOpen
+		cb.openUntil = now.Add(opts.Cooldown)
+		return cb.state, true
+	case cb.state == BreakerHalfOpen:
+		cb.succeeded++
+		if cb.succeeded < max(opts.Probes, 1) {
+			return cb.state, false
+		}
+		delete(b.circuits, host)
+		return BreakerClosed, true
+	case cb.state == BreakerClosed && failed:
+		cb.failures++
+		if cb.failures < opts.FailureThreshold {
+			return cb.state, false
+		}
+		cb.state = BreakerOpen
+		cb.openUntil = now.Add(opts.Cooldown)
+		return cb.state, true
+	case cb.state == BreakerClosed:
+		delete(b.circuits, host)
+	}
+
+	return cb.state, false
+}
+
+// release gives back a probe slot of a request that ended without an outcome, e.g. it was cancelled.
+func (b *circuitBreaker) release(host string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if cb, ok := b.circuits[host]; ok && cb.state == BreakerHalfOpen && cb.probes > 0 {
+		cb.probes--
+	}
+}
+
+func (b *circuitBreaker) state(host string) BreakerState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if cb, ok := b.circuits[host]; ok {
+		return cb.state
+	}
+
+	return BreakerClosed
+}
+
+func (b *circuitBreaker) reset(host string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	delete(b.circuits, host)
+}
+
+func (b *circuitBreaker) resetAll() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.circuits = map[string]*circuit{}
+}
+
+// CircuitState returns the state of a host's circuit.
+func (c *HttpClient) CircuitState(host string) BreakerState {
+	return c.breaker.state(host)
+}
+
+// acquireCircuit admits a request through its host's circuit, requests are always
+// admitted if the circuit breaker is disabled or the request is nested.
+func (c *HttpClient) acquireCircuit(uow PendingRequest) error {
+	opts := c.Options.ErrorHandling.CircuitBreaker
+	if opts.FailureThreshold <= 0 || uow.nested {
+		return nil
+	}
+
+	state, err := c.breaker.acquire(uow.Message.Request.URL.Host, time.Now(), opts)
+	if err == nil && state == BreakerHalfOpen {
+		gologger.Debug().Msgf("probing %s", uow.Message.Request.URL.Host)
+	}
+
+	return err
+}
+
+// reportCircuit records the outcome of a request admitted through its host's circuit,
+// when the circuit opens the host's queued requests fail fast.
+func (c *HttpClient) reportCircuit(uow PendingRequest) {
+	opts := c.Options.ErrorHandling.CircuitBreaker
+	if opts.FailureThreshold <= 0 || uow.nested {
+		return
+	}
+
+	host := uow.Message.Request.URL.Host
+	if uow.Message.TransportError == Cancelled || uow.Message.TransportError == Halted {
+		c.breaker.release(host)
+		return
+	}
+
+	state, changed := c.breaker.report(host, uow.Message.TransportError != NoError, time.Now(), opts)
+	if !changed {
+		return
+	}
+
+	gologger.Warning().Msgf("circuit of %s is %s", host, state)
+	c.Events.circuitChange(host, state)
+
+	if state == BreakerOpen {
+		err := c.breaker.rejects(host, time.Now())
+		for _, queued := range c.ThreadPool.removeWhere(func(queued PendingRequest) bool { return !queued.nested && queued.host == host }) {
+			c.handleCircuitOpen(queued, err)
+		}
+	}
+}
+
+func (c *HttpClient) handleCircuitOpen(uow PendingRequest, err error) {
+	uow.Message.TransportError = CircuitOpen
+	uow.Message.Error = err
+	uow.cancel()
+	c.complete(uow)
+}
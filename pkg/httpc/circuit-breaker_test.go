@@ -0,0 +1,141 @@
+package httpc
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var testBreakerOptions = CircuitBreakerOptions{FailureThreshold: 3, Cooldown: time.Minute, Probes: 2}
+
+func openCircuit(t *testing.T, b *circuitBreaker, host string, now time.Time) {
+	t.Helper()
+
+	for i := 0; i < testBreakerOptions.FailureThreshold; i++ {
+		b.report(host, true, now, testBreakerOptions)
+	}
+	if state := b.state(host); state != BreakerOpen {
+		t.Fatalf("expected the circuit to open, got %s", state)
+	}
+}
+
+func TestCircuitOpensAfterConsecutiveFailures(t *testing.T) {
+	b := newCircuitBreaker()
+	now := time.Now()
+
+	b.report("a", true, now, testBreakerOptions)
+	b.report("a", true, now, testBreakerOptions)
+	// a success in between resets the consecutive count
+	b.report("a", false, now, testBreakerOptions)
+	b.report("a", true, now, testBreakerOptions)
+	b.report("a", true, now, testBreakerOptions)
+	if state := b.state("a"); state != BreakerClosed {
+		t.Fatalf("expected the circuit to stay closed, got %s", state)
+	}
+
+	state, changed := b.report("a", true, now, testBreakerOptions)
+	if state != BreakerOpen || !changed {
+		t.Fatalf("expected the circuit to open, got %s (changed: %t)", state, changed)
+	}
+
+	if state := b.state("b"); state != BreakerClosed {
+		t.Fatalf("expected other hosts to stay closed, got %s", state)
+	}
+}
+
+func TestCircuitRejectsUntilCooldown(t *testing.T) {
+	b := newCircuitBreaker()
+	now := time.Now()
+	openCircuit(t, b, "a", now)
+
+	err := b.rejects("a", now.Add(time.Second))
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+
+	var openErr *CircuitOpenError
+	if !errors.As(err, &openErr) || !openErr.Until.Equal(now.Add(time.Minute)) {
+		t.Fatalf("expected the circuit to be open until the cooldown ends, got %v", err)
+	}
+
+	if _, err := b.acquire("a", now.Add(time.Second), testBreakerOptions); err == nil {
+		t.Fatal("expected requests to be rejected during the cooldown")
+	}
+
+	if err := b.rejects("a", now.Add(time.Minute)); err != nil {
+		t.Fatalf("expected no rejection after the cooldown, got %v", err)
+	}
+}
+
+func TestCircuitHalfOpenProbes(t *testing.T) {
+	b := newCircuitBreaker()
+	now := time.Now()
+	openCircuit(t, b, "a", now)
+
+	after := now.Add(time.Minute)
+	for i := 0; i < testBreakerOptions.Probes; i++ {
+		state, err := b.acquire("a", after, testBreakerOptions)
+		if err != nil || state != BreakerHalfOpen {
+			t.Fatalf("probe %d: expected to be admitted half-open, got %s, %v", i, state, err)
+		}
+	}
+
+	later := after.Add(time.Second)
+	_, err := b.acquire("a", later, testBreakerOptions)
+	var openErr *CircuitOpenError
+	if !errors.As(err, &openErr) {
+		t.Fatalf("expected requests beyond the probes to be rejected, got %v", err)
+	}
+	if !openErr.Until.After(later) {
+		t.Fatalf("expected the rejection to be valid past now, until %s", openErr.Until)
+	}
+
+	// a released probe slot can be taken again
+	b.release("a")
+	if _, err := b.acquire("a", later, testBreakerOptions); err != nil {
+		t.Fatalf("expected the released slot to be admitted, got %v", err)
+	}
+
+	b.report("a", false, later, testBreakerOptions)
+	if state := b.state("a"); state != BreakerHalfOpen {
+		t.Fatalf("expected the circuit to stay half-open until every probe succeeded, got %s", state)
+	}
+	state, changed := b.report("a", false, later, testBreakerOptions)
+	if state != BreakerClosed || !changed {
+		t.Fatalf("expected the circuit to close, got %s (changed: %t)", state, changed)
+	}
+}
+
+func TestCircuitReopensOnFailedProbe(t *testing.T) {
+	b := newCircuitBreaker()
+	now := time.Now()
+	openCircuit(t, b, "a", now)
+
+	after := now.Add(time.Minute)
+	b.acquire("a", after, testBreakerOptions)
+	state, changed := b.report("a", true, after, testBreakerOptions)
+	if state != BreakerOpen || !changed {
+		t.Fatalf("expected the circuit to reopen, got %s (changed: %t)", state, changed)
+	}
+
+	if err := b.rejects("a", after.Add(time.Second)); err == nil {
+		t.Fatal("expected a new cooldown to start")
+	}
+}
+
+func TestCircuitReset(t *testing.T) {
+	b := newCircuitBreaker()
+	now := time.Now()
+	openCircuit(t, b, "a", now)
+	openCircuit(t, b, "b", now)
+
+	b.reset("a")
+	if state := b.state("a"); state != BreakerClosed {
+		t.Fatalf("expected the reset circuit to be closed, got %s", state)
+	}
+
+	b.resetAll()
+	if state := b.state("b"); state != BreakerClosed {
+		t.Fatalf("expected every circuit to be closed, got %s", state)
+	}
+}
@@ -1,28 +1,20 @@
 package httpc
 
 import (
-	"sync/atomic"
 	"bufio"
-	"bytes"
-	"compress/flate"
-	"compress/gzip"
 	"context"
 	"crypto/tls"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
 	"net/http/httptrace"
 	"net/url"
 	"os"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/andybalholm/brotli"
 	"github.com/aristosMiliaressis/go-ip-rotate/pkg/iprotate"
-	"github.com/aristosMiliaressis/httpc/internal/util"
-	"github.com/corpix/uarand"
 	"github.com/projectdiscovery/gologger"
 	"github.com/projectdiscovery/rawhttp"
 )
@@ -34,18 +26,36 @@ type HttpClient struct {
 	Options    ClientOptions
 	ThreadPool *ThreadPool
 
-	MessageLog MessageLog
+	MessageLog *MessageStore
+	// Recorder writes every completed message to disk if set
+	Recorder *Recorder
+	// Fixtures records or replaces the transport if set
+	Fixtures *Fixtures
+
+	RequestMiddlewares  []RequestMiddleware
+	ResponseMiddlewares []ResponseMiddleware
+	Events              EventHooks
 
 	cookieJar      map[string]string
 	cookieJarMutex sync.RWMutex
 
 	errorLog   map[string]int
 	errorMutex sync.Mutex
-	ipBanCheck atomic.Bool
+	closing    atomic.Bool
+
+	canaries  map[string]Canary
+	baselines map[string]*http.Request
+	banChecks map[string]bool
+	banMutex  sync.Mutex
+
+	haltErr     error
+	haltedHosts map[string]error
+	haltMutex   sync.Mutex
 
-	totalErrors       int
-	totalSuccessful   int
-	consecutiveErrors int
+	stats          *statsCollector
+	breaker        *circuitBreaker
+	errorStats     *errorStats
+	hostErrorStats map[string]*errorStats
 
 	apiGateways     map[string]*iprotate.ApiEndpoint
 	apiGatewayMutex sync.Mutex
@@ -55,171 +65,327 @@ func NewHttpClient(opts ClientOptions, ctx context.Context) *HttpClient {
 	ctx, cancel := context.WithCancel(ctx)
 
 	c := HttpClient{
-		context:   ctx,
-		cancel:    cancel,
-		Options:   opts,
-		client:    createInternalHttpClient(opts),
-		errorLog:  map[string]int{},
-		cookieJar: map[string]string{},
+		context:     ctx,
+		cancel:      cancel,
+		Options:     opts,
+		MessageLog:  NewMessageStore(opts.MessageLog),
+		client:      createInternalHttpClient(opts),
+		errorLog:    map[string]int{},
+		cookieJar:   map[string]string{},
+		haltedHosts: map[string]error{},
+		canaries:    map[string]Canary{},
+		baselines:   map[string]*http.Request{},
+		banChecks:   map[string]bool{},
+
+		stats:          newStatsCollector(),
+		breaker:        newCircuitBreaker(),
+		errorStats:     newErrorStats(opts.ErrorHandling),
+		hostErrorStats: map[string]*errorStats{},
+		apiGateways:    map[string]*iprotate.ApiEndpoint{},
+
+		RequestMiddlewares:  DefaultRequestMiddlewares(),
+		ResponseMiddlewares: DefaultResponseMiddlewares(),
+	}
+
+	if opts.Recorder.Path != "" {
+		recorder, err := NewRecorder(opts.Recorder)
+		if err != nil {
+			gologger.Error().Msgf("failed to create message recorder: %s", err)
+		}
+		c.Recorder = recorder
 	}
 
-	c.ThreadPool = NewThreadPool(c.handleMessage, ctx, opts.Performance.RequestsPerSecond, opts.Performance.Delay, 10000)
+	if opts.Fixtures.Mode != FixturesDisabled {
+		fixtures, err := NewFixtures(opts.Fixtures)
+		if err != nil {
+			gologger.Error().Msgf("failed to load fixtures: %s", err)
+			fixtures = missingFixtures(opts.Fixtures, err)
+		}
+		c.Fixtures = fixtures
+	}
+
+	c.ThreadPool = NewThreadPool(c.handleMessage, ctx, opts.Performance)
+	c.ThreadPool.OnRateChange = func(rps float64, throttleRate float64) {
+		c.Events.rateChange(RateChangeEvent{RequestsPerSecond: rps, ThrottleRate: throttleRate})
+	}
 	go c.ThreadPool.Run()
 
 	return &c
 }
 
+// Wait blocks until all queued and in-flight requests have been processed
+// or the context is done.
+func (c *HttpClient) Wait(ctx context.Context) error {
+	return c.ThreadPool.Wait(ctx)
+}
+
+// Shutdown stops accepting new requests, waits for queued and in-flight
+// requests to finish until the context is done and then closes the client,
+// once the context is done the remaining requests are cancelled without waiting for them.
+// Event hooks and middlewares run on the workers, they have to use Shutdown
+// with a deadline rather than Close since the worker can't wait for itself.
+func (c *HttpClient) Shutdown(ctx context.Context) error {
+	c.closing.Store(true)
+
+	err := c.ThreadPool.Wait(ctx)
+	c.close(ctx)
+
+	return err
+}
+
+// Pause stops sending queued requests, in-flight requests are allowed to finish
+// and everything else stays queued until Resume is called.
+func (c *HttpClient) Pause() {
+	c.ThreadPool.Pause()
+}
+
+// Resume continues sending queued requests and resets the consecutive error count.
+func (c *HttpClient) Resume() {
+	c.errorMutex.Lock()
+	c.errorStats.counts.Consecutive = 0
+	for _, stats := range c.hostErrorStats {
+		stats.counts.Consecutive = 0
+	}
+	c.errorMutex.Unlock()
+
+	c.ThreadPool.Resume()
+}
+
+func (c *HttpClient) IsPaused() bool {
+	return c.ThreadPool.IsPaused()
+}
+
+// Close cancels every pending request and releases the client's resources,
+// spilled messages are removed so the message log must be exported beforehand.
+// Close waits for the workers to stop and must not be called from event hooks or middlewares.
 func (c *HttpClient) Close() {
+	c.close(context.Background())
+}
+
+// close cancels every pending request and waits for the workers to stop until ctx is done.
+func (c *HttpClient) close(ctx context.Context) {
+	c.closing.Store(true)
 	c.cancel()
 
+	c.ThreadPool.waitForWorkers(ctx)
+
+	for _, uow := range c.ThreadPool.drain() {
+		c.handleCancelled(uow)
+	}
+
 	c.apiGatewayMutex.Lock()
 	for k := range c.apiGateways {
 		c.apiGateways[k].Delete()
+		delete(c.apiGateways, k)
 	}
 	c.apiGatewayMutex.Unlock()
 
-	c.ThreadPool.queuePriorityMutex.Lock()
-	for p := range c.ThreadPool.queuePriorityMap {
-		ch := c.ThreadPool.queuePriorityMap[p]
-		delete(c.ThreadPool.queuePriorityMap, p)
-		for len(ch) > 0 {
-			req := <-ch
-			close(req.Message.Resolved)
+	if c.Recorder != nil {
+		c.Recorder.Close()
+	}
+
+	if c.Fixtures != nil {
+		if err := c.Fixtures.Save(); err != nil {
+			gologger.Error().Msgf("failed to save fixtures: %s", err)
 		}
-		close(ch)
 	}
-	c.ThreadPool.queuePriorityMutex.Unlock()
-	close(c.ThreadPool.totalThreads)
-	close(c.ThreadPool.lockedThreads)
+
+	if err := c.MessageLog.Close(); err != nil {
+		gologger.Debug().Msgf("failed to remove message log spill files: %s", err)
+	}
 }
 
-func (c *HttpClient) Send(req *http.Request) *MessageDuplex {
-	return c.SendWithOptions(req, c.Options)
+func (c *HttpClient) Send(req *http.Request) *Future {
+	return c.SendWithOptionsContext(req.Context(), req, c.Options)
 }
 
-func (c *HttpClient) SendWithOptions(req *http.Request, opts ClientOptions) *MessageDuplex {
+func (c *HttpClient) SendWithOptions(req *http.Request, opts ClientOptions) *Future {
+	return c.SendWithOptionsContext(req.Context(), req, opts)
+}
 
-	msg := &MessageDuplex{
-		Request:  req.Clone(c.context),
-		Resolved: make(chan bool, 1),
+// SendContext sends a request that is cancelled when ctx is done, whether it is
+// still queued or already in flight, in which case it ends in the Cancelled state.
+func (c *HttpClient) SendContext(ctx context.Context, req *http.Request) *Future {
+	return c.SendWithOptionsContext(ctx, req, c.Options)
+}
+
+func (c *HttpClient) SendWithOptionsContext(ctx context.Context, req *http.Request, opts ClientOptions) *Future {
+	if c.closing.Load() {
+		return c.rejected(req)
 	}
 
-	if opts.Connection.EnableIPRotate {
-		c.enableIpRotate(msg.Request.URL)
+	return c.enqueue(c.newPendingRequest(ctx, req, opts))
+}
+
+// rejected returns an already completed future for requests sent after shutdown.
+func (c *HttpClient) rejected(req *http.Request) *Future {
+	future := newFuture()
+	future.complete(&MessageDuplex{
+		TransportError: Cancelled,
+		Request:        req,
+	})
+
+	return future
+}
+
+func (c *HttpClient) newPendingRequest(ctx context.Context, req *http.Request, opts ClientOptions) PendingRequest {
+	msg, cancel, err := c.prepareMessage(ctx, req, opts)
+
+	return PendingRequest{Message: msg, Options: opts, ctx: ctx, cancel: cancel, future: newFuture(), err: err}
+}
+
+// followUp queues a request that continues uow, e.g. a retry or a redirect,
+// its future is only completed once the follow-up completes.
+func (c *HttpClient) followUp(uow PendingRequest, next PendingRequest) {
+	next.future = uow.future
+	next.retries = uow.retries
+	c.enqueue(next)
+}
+
+// enqueue queues a request and makes sure it is removed from the queue
+// if its context is done before it gets dispatched.
+func (c *HttpClient) enqueue(uow PendingRequest) *Future {
+	var stop func() bool
+	cancel := uow.cancel
+	uow.cancel = func() {
+		stop()
+		cancel()
 	}
 
-	if len(c.apiGateways) > 0 {
-		c.apiGatewayMutex.Lock()
-		for gateway := range c.apiGateways {
-			if GetBaseUrl(req.URL).String() == gateway {
-				replacedUrl := strings.Replace(req.URL.String(), gateway, c.apiGateways[gateway].ProxyUrl, 1)
-				gatewayUrl, err := url.Parse(replacedUrl)
-				if err != nil {
-					gologger.Fatal().Msgf("failed to update url to ip-rotate url")
-				}
-				msg.Request.URL = gatewayUrl
-			}
+	stop = context.AfterFunc(uow.Message.Request.Context(), func() {
+		if c.ThreadPool.remove(uow.Message) {
+			c.handleCancelled(uow)
 		}
-		c.apiGatewayMutex.Unlock()
+	})
+
+	if err := c.haltedError(uow); err != nil {
+		c.handleHalted(uow, err)
+		return uow.future
+	}
+
+	if err := c.breaker.rejects(uow.Message.Request.URL.Host, time.Now()); err != nil && !uow.nested {
+		c.handleCircuitOpen(uow, err)
+		return uow.future
 	}
 
-	if opts.SimulateBrowserRequests {
-		util.SimulateBrowserRequest(msg.Request)
+	if uow.err != nil {
+		gologger.Debug().Msgf("failed to prepare request %s: %s", uow.Message.Request.URL, uow.err)
+		uow.Message.TransportError = UnknownError
+		uow.Message.Error = uow.err
+		uow.cancel()
+		c.complete(uow)
+		return uow.future
+	}
+
+	select {
+	case <-c.context.Done():
+		c.handleCancelled(uow)
+	default:
+		c.Events.queued(uow.Message)
+		c.ThreadPool.Enqueue(uow)
 	}
 
-	if opts.RandomizeUserAgent {
-		msg.Request.Header.Set("User-Agent", uarand.GetRandom())
+	return uow.future
+}
+
+func (c *HttpClient) handleCancelled(uow PendingRequest) {
+	uow.Message.TransportError = Cancelled
+	uow.cancel()
+	c.complete(uow)
+}
+
+// complete logs the final message of a request and resolves its future.
+func (c *HttpClient) complete(uow PendingRequest) {
+	c.stats.record(uow.Message)
+	c.MessageLog.Append(uow.Message)
+	if c.Recorder != nil {
+		if err := c.Recorder.Record(uow.Message); err != nil {
+			gologger.Error().Msgf("failed to record message: %s", err)
+		}
 	}
+	uow.future.complete(uow.Message)
+}
+
+// requestContext derives a context from the client's context that is also cancelled when ctx is done.
+func (c *HttpClient) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	reqCtx, cancel := context.WithCancel(c.context)
+	stop := context.AfterFunc(ctx, cancel)
 
-	for k, v := range opts.DefaultHeaders {
-		msg.Request.Header.Set(k, v)
+	return reqCtx, func() {
+		stop()
+		cancel()
 	}
+}
+
+func (c *HttpClient) prepareMessage(ctx context.Context, req *http.Request, opts ClientOptions) (*MessageDuplex, context.CancelFunc, error) {
+	reqCtx, cancel := c.requestContext(ctx)
 
-	for k, v := range msg.Request.Header {
-		msg.Request.Header.Set(k, v[0])
+	msg := &MessageDuplex{
+		Request: req.Clone(reqCtx),
+		Tags:    opts.Tags,
 	}
 
-	if msg.Request.ProtoMajor == 2 {
-		msg.Request.Header.Del("Connection")
-		msg.Request.Header.Del("Upgrade")
-		msg.Request.Header.Del("Transfer-Encoding")
+	if err := msg.storeRequestBody(c.MessageLog.bodies); err != nil {
+		return msg, cancel, err
 	}
 
-	for k, v := range c.GetCookieJar() {
-		if ContainsCookie(msg.Request, k) || util.Contains(opts.ExcludeCookies, k) {
-			continue
+	for _, middleware := range c.RequestMiddlewares {
+		if err := middleware.ProcessRequest(c, msg, opts); err != nil {
+			return msg, cancel, err
 		}
-		msg.Request.AddCookie(&http.Cookie{Name: k, Value: v})
 	}
 
-	opts.CacheBusting.Apply(msg.Request)
-
-	var start time.Time
+	// the hooks run on the transport's write and read goroutines, which may overlap
+	var start atomic.Int64
 	trace := &httptrace.ClientTrace{
 		WroteRequest: func(_ httptrace.WroteRequestInfo) {
 			// begin the timer after the request is fully written
-			start = time.Now()
+			start.Store(time.Now().UnixNano())
 		},
 		GotFirstResponseByte: func() {
 			// record when the first byte of the response was received
-			msg.Duration = time.Since(start)
+			msg.Duration = time.Since(time.Unix(0, start.Load()))
 		},
 	}
 
-	msg.Request = msg.Request.WithContext(httptrace.WithClientTrace(c.context, trace))
+	msg.Request = msg.Request.WithContext(httptrace.WithClientTrace(reqCtx, trace))
 
-	c.ThreadPool.queuePriorityMutex.Lock()
-	queue, ok := c.ThreadPool.queuePriorityMap[opts.RequestPriority]
-	if !ok {
-		queue = c.ThreadPool.NewRequestQueue()
-		c.ThreadPool.queuePriorityMap[opts.RequestPriority] = queue
-	}
-	c.ThreadPool.queuePriorityMutex.Unlock()
-
-	select {
-	case <-c.context.Done():
-		close(msg.Resolved)
-		return msg
-	default:
-		queue <- PendingRequest{"", msg, opts}
-	}
+	return msg, cancel, nil
+}
 
-	return msg
+func (c *HttpClient) SendRaw(rawreq string, baseUrl string) *Future {
+	return c.SendRawWithOptionsContext(context.Background(), rawreq, baseUrl, c.Options)
 }
 
-func (c *HttpClient) SendRaw(rawreq string, baseUrl string) *MessageDuplex {
-	return c.SendRawWithOptions(rawreq, baseUrl, c.Options)
+func (c *HttpClient) SendRawWithOptions(rawreq string, baseUrl string, opts ClientOptions) *Future {
+	return c.SendRawWithOptionsContext(context.Background(), rawreq, baseUrl, opts)
 }
 
-func (c *HttpClient) SendRawWithOptions(rawreq string, baseUrl string, opts ClientOptions) *MessageDuplex {
+func (c *HttpClient) SendRawContext(ctx context.Context, rawreq string, baseUrl string) *Future {
+	return c.SendRawWithOptionsContext(ctx, rawreq, baseUrl, c.Options)
+}
 
-	msg := &MessageDuplex{
-		Resolved: make(chan bool, 1),
+func (c *HttpClient) SendRawWithOptionsContext(ctx context.Context, rawreq string, baseUrl string, opts ClientOptions) *Future {
+	if c.closing.Load() {
+		req, _ := http.NewRequest("GET", baseUrl, nil)
+		return c.rejected(req)
 	}
-	msg.Request, _ = http.NewRequest("GET", baseUrl, nil)
 
-	c.ThreadPool.queuePriorityMutex.Lock()
-	queue, ok := c.ThreadPool.queuePriorityMap[opts.RequestPriority]
-	if !ok {
-		queue = c.ThreadPool.NewRequestQueue()
-		c.ThreadPool.queuePriorityMap[opts.RequestPriority] = queue
-	}
-	c.ThreadPool.queuePriorityMutex.Unlock()
+	return c.enqueue(c.newRawPendingRequest(ctx, rawreq, baseUrl, opts))
+}
 
-	select {
-	case <-c.context.Done():
-		close(msg.Resolved)
-		return msg
-	default:
-		queue <- PendingRequest{rawreq, msg, opts}
-	}
+func (c *HttpClient) newRawPendingRequest(ctx context.Context, rawreq string, baseUrl string, opts ClientOptions) PendingRequest {
+	reqCtx, cancel := c.requestContext(ctx)
 
-	return msg
+	msg := &MessageDuplex{Tags: opts.Tags}
+	msg.Request, _ = http.NewRequestWithContext(reqCtx, "GET", baseUrl, nil)
+
+	return PendingRequest{RawRequest: rawreq, Message: msg, Options: opts, ctx: ctx, cancel: cancel, future: newFuture()}
 }
 
 func (c *HttpClient) ConnectRequest(proxyUrl *url.URL, destUrl *url.URL, opts ClientOptions) *MessageDuplex {
 	msg := MessageDuplex{}
-	c.MessageLog = append(c.MessageLog, &msg)
+	c.MessageLog.Append(&msg)
 
 	proxyAddr := proxyUrl.Host
 	if proxyUrl.Port() == "" {
@@ -299,12 +465,38 @@ func createInternalHttpClient(opts ClientOptions) http.Client {
 }
 
 func (c *HttpClient) handleMessage(uow PendingRequest) {
-	defer func() { uow.Message.Resolved <- true }()
+	defer uow.cancel()
+
+	if uow.Message.Request.Context().Err() != nil {
+		uow.Message.TransportError = Cancelled
+		c.complete(uow)
+		return
+	}
+
+	if err := c.haltedError(uow); err != nil {
+		uow.Message.TransportError = Halted
+		uow.Message.Error = err
+		c.complete(uow)
+		return
+	}
+
+	if err := c.acquireCircuit(uow); err != nil {
+		uow.Message.TransportError = CircuitOpen
+		uow.Message.Error = err
+		c.complete(uow)
+		return
+	}
+	defer c.reportCircuit(uow)
+
+	c.ThreadPool.SetThrottlePercentage(c.calculate429Percentage())
+
+	uow.Message.Timestamp = time.Now()
+	c.Events.sent(uow.Message)
 
-	c.ThreadPool.Rate.SetRatelimitPercentage(c.calculate429Percentage())
-	
 	var sendErr error
-	if uow.RawRequest == "" {
+	if c.Fixtures != nil && c.Fixtures.opts.Mode == FixturesReplay {
+		uow.Message.Response, sendErr = c.Fixtures.replay(uow)
+	} else if uow.RawRequest == "" {
 		if uow.Options.Connection.SNI != "" {
 			sniClient := createInternalHttpClient(uow.Options)
 
@@ -318,160 +510,153 @@ func (c *HttpClient) handleMessage(uow PendingRequest) {
 		httpclient := rawhttp.NewClient(&opts)
 		defer httpclient.Close()
 
-		uow.Message.Response, sendErr = httpclient.DoRaw("GET", uow.Message.Request.URL.String(), "", nil, nil)
+		uow.Message.Response, sendErr = doRaw(uow.Message.Request.Context(), httpclient, uow.Message.Request.URL.String())
+	}
+
+	if sendErr == nil {
+		body, err := c.MessageLog.bodies.store(uow.Message.Response.Body)
+		uow.Message.Response.Body.Close()
+		uow.Message.setResponseBody(body)
+		if err != nil && uow.Message.Request.Context().Err() != nil {
+			// the body is truncated, so the response is neither processed nor recorded
+			uow.Message.TransportError = Cancelled
+			uow.Message.Error = &TransportFailure{Kind: Cancelled, Err: err}
+			c.complete(uow)
+			return
+		}
+		if err != nil {
+			gologger.Debug().Msgf("failed to read response body of %s: %s", uow.Message.Request.URL, err)
+		}
 	}
 
-	c.MessageLog = append(c.MessageLog, uow.Message)
+	if c.Fixtures != nil && c.Fixtures.opts.Mode == FixturesRecord {
+		if err := c.Fixtures.record(uow, sendErr); err != nil {
+			gologger.Error().Msgf("failed to record fixture: %s", err)
+		}
+	}
 
 	// handle transport errors
 	if sendErr != nil {
 		c.handleTransportError(uow.Message, sendErr)
-		return
-	}
+		if uow.Message.TransportError != Cancelled {
+			c.Events.error(uow.Message, sendErr)
+		}
 
-	if uow.Message.Response == nil && uow.Options.ErrorHandling.RetryTransportFailures {
-		uow.Message.Resolved <- true
-		if uow.RawRequest == "" {
-			retriedMsg := c.SendWithOptions(uow.Message.Request, uow.Options)
-			*uow.Message = *retriedMsg
-		} else {
-			retriedMsg := c.SendRawWithOptions(uow.RawRequest, uow.Message.Request.URL.String(), uow.Options)
-			*uow.Message = *retriedMsg
+		if uow.Message.TransportError != Cancelled && uow.Options.ErrorHandling.RetryTransportFailures &&
+			uow.retries < uow.Options.ErrorHandling.MaxRetries {
+			c.retry(uow)
+			return
 		}
+
+		c.complete(uow)
 		return
 	}
 
 	gologger.Debug().Msgf("URL %s\tStatus: %d\n", uow.Message.Request.URL.String(), uow.Message.Response.StatusCode)
-	gologger.Debug().Msg(c.GetErrorSummary())
 
-	// Update cookie jar
-	if uow.Options.MaintainCookieJar && uow.Message.Response.Cookies() != nil {
-		for _, cookie := range uow.Message.Response.Cookies() {
-			c.AddCookie(cookie.Name, cookie.Value)
-		}
-	}
-
-	var dcprsErr error
-	if uow.Message.Response != nil && uow.Message.Response.Body != nil {
-		var body []byte
-		orig, _ := io.ReadAll(uow.Message.Response.Body)
-		switch uow.Message.Response.Header.Get("Content-Encoding") {
-		case "gzip":
-			reader, readErr := gzip.NewReader(bytes.NewBuffer(orig))
-			if readErr == nil {
-				defer reader.Close()
-				body, dcprsErr = io.ReadAll(reader)
-			} else {
-				body = orig
+	var followUp *FollowUp
+	for _, middleware := range c.ResponseMiddlewares {
+		var err error
+		followUp, err = middleware.ProcessResponse(c, uow.Message, uow.Options)
+		if err != nil {
+			if uow.Message.Request.Context().Err() != nil {
+				uow.Message.TransportError = Cancelled
 			}
-		case "br":
-			reader := brotli.NewReader(bytes.NewBuffer(orig))
-			body, dcprsErr = io.ReadAll(reader)
-		case "deflate":
-			reader := flate.NewReader(bytes.NewBuffer(orig))
-			defer reader.Close()
-			body, dcprsErr = io.ReadAll(reader)
-		default:
-			body, dcprsErr = io.ReadAll(bytes.NewBuffer(orig))
+			gologger.Debug().Msg(err.Error())
+			break
 		}
 
-		uow.Message.Response.Body = io.NopCloser(bytes.NewBuffer(body))
-		uow.Message.Response.ContentLength = int64(len(body))
+		if followUp != nil {
+			break
+		}
 	}
 
-	if dcprsErr != nil {
-		gologger.Debug().Msgf("Error while reading response %s", dcprsErr)
+	c.Events.response(uow.Message)
+
+	if followUp != nil {
+		c.sendFollowUp(uow, followUp)
 		return
 	}
 
-	// handle http errors
-	if uow.Message.TransportError != NoError || (uow.Message.Response.StatusCode >= 400 && uow.Options.ErrorHandling.Matches(uow.Message.Response.StatusCode)) {
-		c.totalErrors += 1
-		c.consecutiveErrors += 1
-		c.handleHttpError(uow.Message)
+	c.complete(uow)
+}
+
+// retry logs the failed attempt and queues the request again.
+func (c *HttpClient) retry(uow PendingRequest) {
+	c.stats.record(uow.Message)
+	c.MessageLog.Append(uow.Message)
+
+	var next PendingRequest
+	if uow.RawRequest == "" {
+		next = c.newPendingRequest(uow.ctx, uow.Message.Request, uow.Options)
 	} else {
-		c.totalSuccessful += 1
-		c.consecutiveErrors = 0
+		next = c.newRawPendingRequest(uow.ctx, uow.RawRequest, uow.Message.Request.URL.String(), uow.Options)
 	}
 
-	// handle redirects
-	if uow.Message.Response.StatusCode >= 300 && uow.Message.Response.StatusCode <= 399 {
-		if uow.Message.Response.Request == nil {
-			uow.Message.Response.Request = uow.Message.Request
-		}
+	uow.retries++
+	c.Events.retry(next.Message, uow.retries)
+	c.followUp(uow, next)
+}
 
-		absRedirect := GetRedirectLocation(uow.Message.Response)
+// sendFollowUp queues a follow-up returned by a response middleware,
+// redirects are linked to the current message while anything else replaces it.
+func (c *HttpClient) sendFollowUp(uow PendingRequest, followUp *FollowUp) {
+	c.stats.record(uow.Message)
 
-		if uow.Options.Redirection.PreventCrossOriginRedirects && IsCrossOrigin(uow.Message.Request.URL.String(), absRedirect) {
-			return
-		}
+	next := c.newPendingRequest(uow.ctx, followUp.Request, followUp.Options)
+	if followUp.Redirect {
+		next.Message.Prev = uow.Message
+		c.Events.redirect(uow.Message, next.Message)
+	} else {
+		c.MessageLog.Append(uow.Message)
+	}
 
-		if uow.Options.Redirection.PreventCrossSiteRedirects && IsCrossSite(uow.Message.Request.URL.String(), absRedirect) {
-			return
-		}
+	c.followUp(uow, next)
+}
 
-		uow.Options.Redirection.currentDepth++
-		if uow.Options.Redirection.currentDepth > uow.Options.Redirection.MaxRedirects {
-			return
-		}
+func (c *HttpClient) calculate429Percentage() uint8 {
+	if !c.Options.Performance.AutoRateThrottle {
+		return 0
+	}
 
-		if !uow.Options.Redirection.FollowRedirects {
-			return
-		}
+	recent := c.MessageLog.Recent(100)
+	if len(recent) == 0 {
+		return 0
+	}
 
-		redirectedReq := uow.Message.Request.Clone(c.context)
-		uow.Options.CacheBusting.Clear(redirectedReq)
-
-		absRedirectUrl, _ := url.Parse(absRedirect)
-		redirectedReq.Host = absRedirectUrl.Host
-		redirectedReq.URL, _ = url.Parse(absRedirect)
-
-		newMsg := c.SendWithOptions(redirectedReq, uow.Options)
-		c.ThreadPool.lockedThreads <- true
-		<-newMsg.Resolved
-		<-c.ThreadPool.lockedThreads
-
-		c.MessageLog = append(c.MessageLog, newMsg)
-		
-		tmpMsg := MessageDuplex{
-			Request: uow.Message.Request,
-			Response: uow.Message.Response,
-			TransportError: uow.Message.TransportError,
-			Duration: uow.Message.Duration,
-			Prev: uow.Message.Prev,
+	rateLimitedRequests := recent.Search(func(msg *MessageDuplex) bool {
+		if msg.Waf != nil && msg.Waf.Verdict != WafBlock && c.Options.WafDetection.ThrottleOnChallenge {
+			return true
 		}
 
-		uow.Message.Request = newMsg.Request
-		uow.Message.Response = newMsg.Response
-		uow.Message.TransportError = newMsg.TransportError
-		uow.Message.Duration = newMsg.Duration
-		uow.Message.Prev = &tmpMsg
-
-		return
-	}
+		return msg.Response != nil && msg.Response.StatusCode == 429
+	})
 
-	// handle rate-limitting
-	if uow.Message.Response.StatusCode == 429 || uow.Message.Response.StatusCode == 529 {
-		if uow.Options.Performance.ReplayRateLimitted {
-			replayReq := uow.Message.Request.Clone(c.context)
-			uow.Message = c.SendWithOptions(replayReq, uow.Options)
-		}
-	}
+	return uint8(float32(len(rateLimitedRequests)) / float32(len(recent)) * 100)
 }
 
-func (c *HttpClient) calculate429Percentage() uint8 {
-	if !c.Options.Performance.AutoRateThrottle || len(c.MessageLog) == 0 {
-		return 0
+// doRaw sends a raw request, returning early with the context's error if it is done first.
+func doRaw(ctx context.Context, client *rawhttp.Client, url string) (*http.Response, error) {
+	type result struct {
+		resp *http.Response
+		err  error
 	}
-	
-	idx := len(c.MessageLog) - 100
-	if idx < 0 {
-		idx = 0
+
+	done := make(chan result, 1)
+	go func() {
+		resp, err := client.DoRaw("GET", url, "", nil, nil)
+		done <- result{resp, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.resp, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.resp != nil {
+				r.resp.Body.Close()
+			}
+		}()
+		return nil, ctx.Err()
 	}
-	
-	rateLimitedRequests := c.MessageLog[idx:].Search(func(msg *MessageDuplex) bool {
-		return msg.Response != nil && msg.Response.StatusCode == 429
-	})
-	
-	return uint8(float32(len(rateLimitedRequests)) / float32(len(c.MessageLog[idx:])) * 100)
 }
@@ -0,0 +1,430 @@
+package httpc_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aristosMiliaressis/httpc/pkg/httpc"
+	"github.com/aristosMiliaressis/httpc/pkg/httpctest"
+	"golang.org/x/net/http2"
+)
+
+func TestCircuitBreakerFailsFast(t *testing.T) {
+	srv := httpctest.NewServer().Script(httpctest.Rule{Behavior: httpctest.Reset()})
+	defer srv.Close()
+
+	c := httpctest.NewClient(t, func(opts *httpc.ClientOptions) {
+		opts.ErrorHandling.CircuitBreaker.FailureThreshold = 2
+		opts.ErrorHandling.CircuitBreaker.Cooldown = time.Minute
+	})
+
+	for i := 0; i < 2; i++ {
+		if msg := httpctest.Send(t, c, "GET", srv.URL, ""); msg.TransportError != httpc.ConnectionReset {
+			t.Fatalf("expected ConnectionReset, got %s", msg.TransportError)
+		}
+	}
+
+	msg := httpctest.Send(t, c, "GET", srv.URL, "")
+	if msg.TransportError != httpc.CircuitOpen {
+		t.Fatalf("expected CircuitOpen, got %s", msg.TransportError)
+	}
+	if c.CircuitState(msg.Request.URL.Host) != httpc.BreakerOpen {
+		t.Fatalf("expected the circuit to be open")
+	}
+
+	httpctest.AssertServerRequests(t, srv, 2)
+	httpctest.AssertTransportErrors(t, c, httpc.ConnectionReset, 2)
+}
+
+func TestPercentageThresholdHaltsHost(t *testing.T) {
+	srv := httpctest.NewServer().Script(httpctest.Rule{Path: "/fail", Behavior: httpctest.Reset()})
+	defer srv.Close()
+
+	c := httpctest.NewClient(t, func(opts *httpc.ClientOptions) {
+		opts.ErrorHandling.PercentageThreshold = 50
+		opts.ErrorHandling.PercentageMinRequests = 4
+		opts.ErrorHandling.ErrorWindow = 10
+	})
+
+	for i := 0; i < 2; i++ {
+		httpctest.Send(t, c, "GET", srv.URL+"/ok", "")
+	}
+	for i := 0; i < 2; i++ {
+		httpctest.Send(t, c, "GET", srv.URL+"/fail", "")
+	}
+	httpctest.AssertNotHalted(t, c, srv)
+
+	// the fifth request exceeds the minimum with 60% of the window failed
+	httpctest.Send(t, c, "GET", srv.URL+"/fail", "")
+	httpctest.AssertHalted(t, c, srv)
+
+	if msg := httpctest.Send(t, c, "GET", srv.URL+"/ok", ""); msg.TransportError != httpc.Halted {
+		t.Fatalf("expected requests to the halted host to fail, got %s", msg.TransportError)
+	}
+	httpctest.AssertServerRequests(t, srv, 5)
+}
+
+func TestRecorderRecordsRedirectChains(t *testing.T) {
+	srv := httpctest.NewServer().Script(httpctest.Rule{Path: "/old", Behavior: httpctest.Redirect("/new", http.StatusFound)})
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "messages.jsonl")
+	c := httpctest.NewClient(t, func(opts *httpc.ClientOptions) {
+		opts.Recorder.Path = path
+	})
+	httpctest.Send(t, c, "GET", srv.URL+"/old", "")
+	c.Close()
+
+	log, err := httpc.LoadRecording(httpc.RecorderOptions{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log) != 1 || log[0].Request.URL.Path != "/new" || log[0].RedirectDepth() != 1 {
+		t.Fatalf("expected one message reached through a redirect, got %d", len(log))
+	}
+	if prev := log[0].Prev; prev.Response.StatusCode != http.StatusFound || prev.Request.URL.Path != "/old" {
+		t.Fatalf("expected the redirect to be recorded, got %d %s", prev.Response.StatusCode, prev.Request.URL)
+	}
+}
+
+func TestWaitAndShutdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		stall    time.Duration
+		shutdown bool
+		wantErr  error
+	}{
+		{"wait drains", 20 * time.Millisecond, false, nil},
+		{"wait times out", time.Second, false, context.DeadlineExceeded},
+		{"shutdown drains", 20 * time.Millisecond, true, nil},
+		{"shutdown times out", time.Second, true, context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httpctest.NewServer().Script(httpctest.Rule{Behavior: httpctest.Stall(tt.stall)})
+			defer srv.Close()
+
+			c := httpctest.NewClient(t, func(opts *httpc.ClientOptions) {
+				opts.Performance.MaxConcurrency = 2
+			})
+
+			futures := []*httpc.Future{}
+			for i := 0; i < 4; i++ {
+				req, _ := http.NewRequest("GET", srv.URL, nil)
+				futures = append(futures, c.Send(req))
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+
+			var err error
+			if tt.shutdown {
+				err = c.Shutdown(ctx)
+			} else {
+				err = c.Wait(ctx)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+
+			if tt.wantErr == nil {
+				for _, future := range futures {
+					if msg := future.Message(); msg == nil || msg.Response == nil {
+						t.Fatal("expected every request to be answered")
+					}
+				}
+			}
+
+			if tt.shutdown {
+				// requests are refused once the client shuts down
+				if msg := httpctest.Send(t, c, "GET", srv.URL, ""); msg.TransportError != httpc.Cancelled {
+					t.Fatalf("expected requests after shutdown to be cancelled, got %s", msg.TransportError)
+				}
+				for _, future := range futures {
+					if msg := httpctest.Await(t, future); msg.Response == nil && msg.TransportError != httpc.Cancelled {
+						t.Fatalf("expected requests to be answered or cancelled, got %s", msg.TransportError)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestShutdownFromHook(t *testing.T) {
+	srv := httpctest.NewServer()
+	defer srv.Close()
+
+	done := make(chan error, 1)
+	c := httpctest.NewClient(t, nil)
+	c.Events.OnResponse = func(msg *httpc.MessageDuplex) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		done <- c.Shutdown(ctx)
+	}
+
+	httpctest.Send(t, c, "GET", srv.URL, "")
+
+	select {
+	case err := <-done:
+		// the hook's own worker is still in flight
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected the wait to time out, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Shutdown called from a hook to return")
+	}
+}
+
+func TestPauseAndResume(t *testing.T) {
+	tests := []struct {
+		name         string
+		pause        func(c *httpc.HttpClient, host string)
+		resume       func(c *httpc.HttpClient, host string)
+		otherBlocked bool
+	}{
+		{"client", func(c *httpc.HttpClient, host string) { c.Pause() }, func(c *httpc.HttpClient, host string) { c.Resume() }, true},
+		{"host", (*httpc.HttpClient).PauseHost, (*httpc.HttpClient).ResumeHost, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paused := httpctest.NewServer()
+			defer paused.Close()
+			other := httpctest.NewServer()
+			defer other.Close()
+
+			c := httpctest.NewClient(t, nil)
+			host := strings.TrimPrefix(paused.URL, "http://")
+			tt.pause(c, host)
+
+			req, _ := http.NewRequest("GET", paused.URL, nil)
+			future := c.Send(req)
+			req, _ = http.NewRequest("GET", other.URL, nil)
+			otherFuture := c.Send(req)
+
+			time.Sleep(100 * time.Millisecond)
+			if future.Message() != nil {
+				t.Fatal("expected the request to stay queued while paused")
+			}
+			httpctest.AssertServerRequests(t, paused, 0)
+			if blocked := otherFuture.Message() == nil; blocked != tt.otherBlocked {
+				t.Fatalf("expected requests to other hosts to be blocked: %v, got %v", tt.otherBlocked, blocked)
+			}
+
+			tt.resume(c, host)
+			if msg := httpctest.Await(t, future); msg.Response == nil {
+				t.Fatalf("expected the request to be sent once resumed, got %s", msg.TransportError)
+			}
+			httpctest.Await(t, otherFuture)
+			httpctest.AssertServerRequests(t, paused, 1)
+			httpctest.AssertServerRequests(t, other, 1)
+		})
+	}
+}
+
+func TestPauseIfThresholdExceeded(t *testing.T) {
+	srv := httpctest.NewServer().Script(httpctest.Rule{Path: "/fail", Behavior: httpctest.Reset()})
+	defer srv.Close()
+
+	c := httpctest.NewClient(t, func(opts *httpc.ClientOptions) {
+		opts.ErrorHandling.ConsecutiveThreshold = 2
+		opts.ErrorHandling.PauseIfExheeded = true
+	})
+
+	for i := 0; i < 3; i++ {
+		httpctest.Send(t, c, "GET", srv.URL+"/fail", "")
+	}
+	if !c.IsPaused() {
+		t.Fatal("expected the client to pause after exceeding the consecutive error threshold")
+	}
+
+	req, _ := http.NewRequest("GET", srv.URL+"/ok", nil)
+	future := c.Send(req)
+
+	c.Resume()
+	if c.IsPaused() || c.ErrorCounts().Consecutive != 0 {
+		t.Fatalf("expected Resume to unpause and reset the consecutive errors, got %d", c.ErrorCounts().Consecutive)
+	}
+	if msg := httpctest.Await(t, future); msg.Response == nil {
+		t.Fatalf("expected the queued request to be sent once resumed, got %s", msg.TransportError)
+	}
+}
+
+func TestSendContextCancellation(t *testing.T) {
+	slowBody := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}
+
+	tests := []struct {
+		name string
+		path string
+		// blocked occupies the only worker so that the request stays queued
+		blocked bool
+		// headers is whether the response headers were received before the request was cancelled
+		headers bool
+	}{
+		{"queued", "/stall", true, false},
+		{"in flight", "/stall", false, false},
+		{"reading body", "/body", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httpctest.NewServer().Script(
+				httpctest.Rule{Path: "/stall", Behavior: httpctest.Stall(5 * time.Second)},
+				httpctest.Rule{Path: "/body", Behavior: slowBody},
+			)
+			defer srv.Close()
+
+			c := httpctest.NewClient(t, func(opts *httpc.ClientOptions) {
+				opts.Performance.MaxConcurrency = 1
+			})
+
+			blockerCtx, cancelBlocker := context.WithCancel(context.Background())
+			defer cancelBlocker()
+			if tt.blocked {
+				req, _ := http.NewRequest("GET", srv.URL+"/stall", nil)
+				c.SendContext(blockerCtx, req)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			req, _ := http.NewRequest("GET", srv.URL+tt.path, nil)
+			future := c.SendContext(ctx, req)
+
+			// cancel once the blocker or the request itself reached the server
+			for deadline := time.Now().Add(5 * time.Second); srv.Requests() < 1 && time.Now().Before(deadline); {
+				time.Sleep(5 * time.Millisecond)
+			}
+			time.Sleep(20 * time.Millisecond)
+			cancel()
+
+			msg := httpctest.Await(t, future)
+			if msg.TransportError != httpc.Cancelled {
+				t.Fatalf("expected the request to be cancelled, got %s", msg.TransportError)
+			}
+			if headers := msg.Response != nil; headers != tt.headers {
+				t.Fatalf("expected response headers to be received: %v, got %v", tt.headers, headers)
+			}
+			// a queued request never reaches the server
+			httpctest.AssertServerRequests(t, srv, 1)
+			httpctest.AssertTransportErrors(t, c, httpc.Cancelled, 1)
+
+			// the worker slot is released well before the server would respond
+			cancelBlocker()
+			waitCtx, cancelWait := context.WithTimeout(context.Background(), time.Second)
+			defer cancelWait()
+			if err := c.Wait(waitCtx); err != nil {
+				t.Fatalf("expected the worker to be released, %d still in flight", c.ThreadPool.GetThreadCount())
+			}
+		})
+	}
+}
+
+func TestRetryTransportFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries int
+		want       httpc.TransportError
+		wantSent   int
+	}{
+		{"retried until answered", 2, httpc.NoError, 3},
+		{"retries exhausted", 1, httpc.ConnectionReset, 2},
+		{"no retries", 0, httpc.ConnectionReset, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mutex sync.Mutex
+			bodies := []string{}
+			reset := httpctest.Reset()
+			srv := httpctest.NewServer().Script(httpctest.Rule{Behavior: func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				mutex.Lock()
+				bodies = append(bodies, string(body))
+				attempt := len(bodies)
+				mutex.Unlock()
+
+				if attempt <= 2 {
+					reset(w, r)
+				}
+			}})
+			defer srv.Close()
+
+			c := httpctest.NewClient(t, func(opts *httpc.ClientOptions) {
+				opts.ErrorHandling.RetryTransportFailures = true
+				opts.ErrorHandling.MaxRetries = tt.maxRetries
+			})
+
+			if msg := httpctest.Send(t, c, "POST", srv.URL, "payload"); msg.TransportError != tt.want {
+				t.Fatalf("expected %s, got %s", tt.want, msg.TransportError)
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if len(bodies) != tt.wantSent {
+				t.Fatalf("expected %d attempts, got %d", tt.wantSent, len(bodies))
+			}
+			for i, body := range bodies {
+				if body != "payload" {
+					t.Fatalf("expected attempt %d to replay the body, got %q", i+1, body)
+				}
+			}
+		})
+	}
+}
+
+func TestTransportErrorClassification(t *testing.T) {
+	tests := []struct {
+		name         string
+		tls          bool
+		behavior     httpctest.Behavior
+		wantKind     httpc.TransportError
+		wantCode     int
+		wantCodeName string
+	}{
+		{"reset", false, httpctest.Reset(), httpc.ConnectionReset, 0, ""},
+		{"closed before headers", false, httpctest.Raw(""), httpc.EofBeforeHeaders, 0, ""},
+		{"malformed status line", false, httpctest.Malformed(), httpc.MalformedResponse, 0, ""},
+		{"raw garbage", false, httpctest.Raw("NOT HTTP\r\n\r\n"), httpc.MalformedResponse, 0, ""},
+		{"goaway", true, httpctest.GoAway(http2.ErrCodeEnhanceYourCalm), httpc.Http2GoAway, 0xb, "ENHANCE_YOUR_CALM"},
+		{"malformed frame", true, httpctest.Malformed(), httpc.MalformedResponse, 0x6, "FRAME_SIZE_ERROR"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httpctest.NewServer()
+			if tt.tls {
+				srv = httpctest.NewTLSServer()
+			}
+			srv.Script(httpctest.Rule{Behavior: tt.behavior})
+			defer srv.Close()
+
+			c := httpctest.NewClient(t, func(opts *httpc.ClientOptions) {
+				opts.Connection.ForceAttemptHTTP2 = tt.tls
+			})
+
+			msg := httpctest.Send(t, c, "GET", srv.URL, "")
+			var failure *httpc.TransportFailure
+			if !errors.As(msg.Error, &failure) {
+				t.Fatalf("expected a transport failure, got %v", msg.Error)
+			}
+			if failure.Kind != tt.wantKind || failure.Code != tt.wantCode || failure.CodeName != tt.wantCodeName {
+				t.Fatalf("expected %s %d %q, got %s %d %q (%v)", tt.wantKind, tt.wantCode, tt.wantCodeName, failure.Kind, failure.Code, failure.CodeName, failure.Err)
+			}
+			if msg.TransportError != tt.wantKind {
+				t.Fatalf("expected the message to record %s, got %s", tt.wantKind, msg.TransportError)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package httpc
+
+import (
+	"time"
+
+	"github.com/projectdiscovery/gologger"
+)
+
+// ErrorCounts are the error statistics of the client or of a single host.
+type ErrorCounts struct {
+	Successful  int
+	Failed      int
+	Consecutive int
+
+	// WindowSuccessful and WindowFailed only count the requests within
+	// the window configured through ErrorHandlingOptions.
+	WindowSuccessful int
+	WindowFailed     int
+}
+
+// Total returns the number of requests counted.
+func (e ErrorCounts) Total() int {
+	return e.Successful + e.Failed
+}
+
+// Percentage returns the percentage of failed requests.
+func (e ErrorCounts) Percentage() int {
+	if e.Total() == 0 {
+		return 0
+	}
+
+	return e.Failed * 100 / e.Total()
+}
+
+// WindowTotal returns the number of requests within the window.
+func (e ErrorCounts) WindowTotal() int {
+	return e.WindowSuccessful + e.WindowFailed
+}
+
+// WindowPercentage returns the percentage of failed requests within the window.
+func (e ErrorCounts) WindowPercentage() int {
+	if e.WindowTotal() == 0 {
+		return 0
+	}
+
+	return e.WindowFailed * 100 / e.WindowTotal()
+}
+
+// errorWindow keeps the results of the most recent requests, limited by count and age,
+// when neither limit is set it only keeps lifetime counts.
+type errorWindow struct {
+	size     int
+	duration time.Duration
+
+	results []windowResult
+	total   int
+	failed  int
+}
+
+type windowResult struct {
+	time   time.Time
+	failed bool
+}
+
+func (w *errorWindow) record(now time.Time, failed bool) {
+	w.total++
+	if failed {
+		w.failed++
+	}
+
+	if w.size <= 0 && w.duration <= 0 {
+		return
+	}
+
+	w.results = append(w.results, windowResult{time: now, failed: failed})
+	w.expire(now)
+}
+
+// expire drops the results that fell out of the window.
+func (w *errorWindow) expire(now time.Time) {
+	drop := 0
+	for _, result := range w.results {
+		if (w.size <= 0 || w.total <= w.size) && (w.duration <= 0 || now.Sub(result.time) <= w.duration) {
+			break
+		}
+
+		w.total--
+		if result.failed {
+			w.failed--
+		}
+		drop++
+	}
+
+	if drop > 0 {
+		w.results = w.results[drop:]
+	}
+}
+
+// errorStats are the error statistics of the client or of a single host,
+// along with whether the percentage threshold is currently exceeded.
+type errorStats struct {
+	counts   ErrorCounts
+	window   errorWindow
+	exceeded bool
+}
+
+func newErrorStats(opts ErrorHandlingOptions) *errorStats {
+	return &errorStats{
+		window: errorWindow{
+			size:     opts.ErrorWindow,
+			duration: opts.ErrorWindowDuration,
+		},
+	}
+}
+
+func (s *errorStats) record(now time.Time, failed bool) {
+	if failed {
+		s.counts.Failed += 1
+		s.counts.Consecutive += 1
+	} else {
+		s.counts.Successful += 1
+		s.counts.Consecutive = 0
+	}
+
+	s.window.record(now, failed)
+}
+
+func (s *errorStats) snapshot(now time.Time) ErrorCounts {
+	s.window.expire(now)
+
+	counts := s.counts
+	counts.WindowFailed = s.window.failed
+	counts.WindowSuccessful = s.window.total - s.window.failed
+
+	return counts
+}
+
+// ErrorCounts returns the error statistics aggregated over all hosts.
+func (c *HttpClient) ErrorCounts() ErrorCounts {
+	c.errorMutex.Lock()
+	defer c.errorMutex.Unlock()
+
+	return c.errorStats.snapshot(time.Now())
+}
+
+// HostErrorCounts returns the error statistics of a single host.
+func (c *HttpClient) HostErrorCounts(host string) ErrorCounts {
+	c.errorMutex.Lock()
+	defer c.errorMutex.Unlock()
+
+	if stats, ok := c.hostErrorStats[host]; ok {
+		return stats.snapshot(time.Now())
+	}
+
+	return ErrorCounts{}
+}
+
+// recordResult counts a request towards the client's and its host's error statistics,
+// it returns the updated statistics of the host.
+func (c *HttpClient) recordResult(msg *MessageDuplex, failed bool) ErrorCounts {
+	host := msg.Request.URL.Host
+	now := time.Now()
+
+	c.errorMutex.Lock()
+	stats, ok := c.hostErrorStats[host]
+	if !ok {
+		stats = newErrorStats(c.Options.ErrorHandling)
+		c.hostErrorStats[host] = stats
+	}
+
+	c.errorStats.record(now, failed)
+	stats.record(now, failed)
+	counts := stats.snapshot(now)
+
+	recovered := stats.exceeded && counts.WindowPercentage() < c.recoveryPercentage()
+	if recovered {
+		stats.exceeded = false
+	}
+	c.errorMutex.Unlock()
+
+	if recovered {
+		gologger.Info().Msgf("%s recovered, error percentage dropped to %d%%", host, counts.WindowPercentage())
+		c.Events.recovered(host)
+	}
+
+	return counts
+}
+
+// setThresholdExceeded updates whether a host exceeds the percentage threshold,
+// it returns false if it already did.
+func (c *HttpClient) setThresholdExceeded(host string, exceeded bool) bool {
+	c.errorMutex.Lock()
+	defer c.errorMutex.Unlock()
+
+	stats, ok := c.hostErrorStats[host]
+	if !ok || stats.exceeded == exceeded {
+		return false
+	}
+	stats.exceeded = exceeded
+
+	return true
+}
+
+func (c *HttpClient) recoveryPercentage() int {
+	if c.Options.ErrorHandling.RecoveryPercentage > 0 {
+		return c.Options.ErrorHandling.RecoveryPercentage
+	}
+
+	return c.Options.ErrorHandling.PercentageThreshold
+}
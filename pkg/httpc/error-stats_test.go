@@ -0,0 +1,125 @@
+package httpc
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestErrorWindow(t *testing.T) {
+	now := time.Now()
+	alternating := []bool{}
+	for i := 0; i < 100; i++ {
+		alternating = append(alternating, i%2 == 0)
+	}
+
+	tests := []struct {
+		name       string
+		window     errorWindow
+		results    []bool
+		interval   time.Duration
+		wantTotal  int
+		wantFailed int
+		wantKept   int
+	}{
+		// only the last 4 results are kept: false, false, false, true
+		{"size", errorWindow{size: 4}, []bool{true, true, false, false, false, true}, 0, 4, 1, 4},
+		// results recorded 45s apart, the first one falls out of the minute
+		{"duration", errorWindow{duration: time.Minute}, []bool{true, true, false}, 45 * time.Second, 2, 1, 2},
+		// lifetime counts without keeping results
+		{"unlimited", errorWindow{}, alternating, 0, 100, 50, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := tt.window
+			for i, failed := range tt.results {
+				w.record(now.Add(time.Duration(i)*tt.interval), failed)
+			}
+
+			if w.total != tt.wantTotal || w.failed != tt.wantFailed || len(w.results) != tt.wantKept {
+				t.Fatalf("expected %d of %d failed with %d kept, got %d of %d with %d kept", tt.wantFailed, tt.wantTotal, tt.wantKept, w.failed, w.total, len(w.results))
+			}
+		})
+	}
+}
+
+func TestErrorWindowExpire(t *testing.T) {
+	w := errorWindow{duration: time.Minute}
+	now := time.Now()
+
+	w.record(now, true)
+	w.record(now.Add(30*time.Second), false)
+	w.expire(now.Add(5 * time.Minute))
+	if w.total != 0 || len(w.results) != 0 {
+		t.Fatalf("expected every result to expire, %d left", w.total)
+	}
+}
+
+func TestErrorStatsSnapshot(t *testing.T) {
+	s := newErrorStats(ErrorHandlingOptions{ErrorWindow: 2})
+	now := time.Now()
+
+	s.record(now, true)
+	s.record(now, true)
+	s.record(now, false)
+	s.record(now, true)
+
+	counts := s.snapshot(now)
+	if counts.Successful != 1 || counts.Failed != 3 || counts.Consecutive != 1 {
+		t.Fatalf("unexpected lifetime counts %+v", counts)
+	}
+	if counts.WindowSuccessful != 1 || counts.WindowFailed != 1 || counts.WindowPercentage() != 50 {
+		t.Fatalf("unexpected window counts %+v", counts)
+	}
+	if counts.Percentage() != 75 {
+		t.Fatalf("expected a lifetime error percentage of 75, got %d", counts.Percentage())
+	}
+}
+
+func TestRecoveryHysteresis(t *testing.T) {
+	opts := ErrorHandlingOptions{PercentageThreshold: 50, RecoveryPercentage: 20, ErrorWindow: 10}
+	c := &HttpClient{
+		Options:        ClientOptions{ErrorHandling: opts},
+		errorStats:     newErrorStats(opts),
+		hostErrorStats: map[string]*errorStats{},
+	}
+
+	recoveries := 0
+	c.Events.OnRecovered = func(host string) { recoveries++ }
+
+	req, _ := http.NewRequest("GET", "http://a.test/", nil)
+	msg := &MessageDuplex{Request: req}
+
+	for i := 0; i < 10; i++ {
+		c.recordResult(msg, true)
+	}
+	if !c.setThresholdExceeded("a.test", true) {
+		t.Fatal("expected the threshold to be marked exceeded")
+	}
+	if c.setThresholdExceeded("a.test", true) {
+		t.Fatal("expected an exceeded threshold not to be reported again")
+	}
+
+	// below the threshold but above the recovery percentage the host hasn't recovered yet
+	for i := 0; i < 6; i++ {
+		if counts := c.recordResult(msg, false); counts.WindowPercentage() < 20 {
+			t.Fatalf("expected the error percentage to stay above 20%%, got %d", counts.WindowPercentage())
+		}
+	}
+	if recoveries != 0 {
+		t.Fatalf("expected no recovery at %d%% errors", c.HostErrorCounts("a.test").WindowPercentage())
+	}
+
+	for i := 0; i < 3; i++ {
+		c.recordResult(msg, false)
+	}
+	if recoveries != 1 {
+		t.Fatalf("expected one recovery below 20%% errors, got %d", recoveries)
+	}
+
+	// recovering resets the exceeded state so the threshold can be exceeded again
+	if !c.setThresholdExceeded("a.test", true) {
+		t.Fatal("expected the threshold to be exceedable again after recovery")
+	}
+}
@@ -2,11 +2,8 @@ package httpc
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
-	"os"
 	"strings"
-	"syscall"
 
 	"github.com/projectdiscovery/gologger"
 )
@@ -21,208 +18,182 @@ const (
 	DnsError
 	UnsupportedProtocolScheme
 	UnknownError
+	Cancelled
+	Halted
+	ConnectionRefused
+	HostUnreachable
+	TlsHandshakeAlert
+	CertificateError
+	ProxyError
+	Http2GoAway
+	Http2StreamReset
+	EofBeforeHeaders
+	MalformedResponse
+	CircuitOpen
+	FixtureMissing
 )
 
+var transportErrorNames = []string{"NoError", "Timeout", "ConnectionReset", "TlsNegotiationFailure", "DnsError", "UnsupportedProtocolScheme", "UnknownError", "Cancelled", "Halted",
+	"ConnectionRefused", "HostUnreachable", "TlsHandshakeAlert", "CertificateError", "ProxyError", "Http2GoAway", "Http2StreamReset", "EofBeforeHeaders", "MalformedResponse", "CircuitOpen", "FixtureMissing"}
+
 func (e TransportError) String() string {
-	return []string{"NoError", "Timeout", "ConnectionReset", "TlsNegotiationFailure", "DnsError", "UnsupportedProtocolScheme", "UnknownError"}[e]
+	return transportErrorNames[e]
 }
 
 func (e TransportError) MarshalJSON() ([]byte, error) {
 	return json.Marshal(e.String())
 }
 
+// MarshalText allows TransportError to be used as a JSON object key.
+func (e TransportError) MarshalText() ([]byte, error) {
+	return []byte(e.String()), nil
+}
+
+func (e *TransportError) UnmarshalText(text []byte) error {
+	for i, name := range transportErrorNames {
+		if name == string(text) {
+			*e = TransportError(i)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown transport error %q", text)
+}
+
+func (e *TransportError) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+
+	return e.UnmarshalText([]byte(name))
+}
+
 func (c *HttpClient) handleTransportError(msg *MessageDuplex, err error) {
 
-	if strings.Contains(err.Error(), "context canceled") {
+	if msg.Request.Context().Err() != nil {
+		msg.TransportError = Cancelled
+		msg.Error = &TransportFailure{Kind: Cancelled, Err: err}
 		return
 	}
 
-	c.errorMutex.Lock()
-	c.totalErrors += 1
-	c.consecutiveErrors += 1
-
-	if os.IsTimeout(err) || errors.Is(err, syscall.ETIME) || errors.Is(err, syscall.ETIMEDOUT) {
-		msg.TransportError = Timeout
-		c.errorLog[Timeout.String()] += 1
-	} else if errors.Is(err, syscall.ECONNRESET) ||
-		strings.Contains(err.Error(), "An existing connection was forcibly closed") ||
-		strings.Contains(err.Error(), "client connection force closed via ClientConn.Close") ||
-		strings.Contains(err.Error(), "server sent GOAWAY and closed the connection") {
-		msg.TransportError = ConnectionReset
-		c.errorLog[ConnectionReset.String()] += 1
-	} else if strings.Contains(err.Error(), "unsupported protocol scheme") {
-		msg.TransportError = UnsupportedProtocolScheme
-		c.errorLog[UnsupportedProtocolScheme.String()] += 1
-	} else {
+	failure := classifyTransportError(err)
+	msg.TransportError = failure.Kind
+	msg.Error = failure
+	if failure.Kind == Cancelled {
+		return
+	}
+	if failure.Kind == UnknownError {
 		gologger.Debug().Msg(err.Error())
-		msg.TransportError = UnknownError
-		c.errorLog[UnknownError.String()] += 1
 	}
-	c.errorMutex.Unlock()
 
-	if c.Options.ErrorHandling.ConsecutiveThreshold != 0 &&
-		c.consecutiveErrors > c.Options.ErrorHandling.ConsecutiveThreshold {
-		exit := true
-		if c.Options.ErrorHandling.VerifyIPBanIfExheeded {
-			exit = c.verifyIpBan(msg)
-		}
-		if exit {
-			if c.Options.ErrorHandling.IpRotateIfExheeded {
-				c.enableIpRotate(msg.Request.URL)
-				return
-			}
-			if c.Options.ErrorHandling.ReportErrorsIfExheeded {
-				gologger.Info().Msg(c.GetErrorSummary())
-			}
-			gologger.Fatal().Msgf("Exceeded %d consecutive errors threshold, exiting.", c.Options.ErrorHandling.ConsecutiveThreshold)
-		}
-	}
+	c.errorMutex.Lock()
+	c.errorLog[failure.Kind.String()] += 1
+	c.errorMutex.Unlock()
 
-	if c.Options.ErrorHandling.PercentageThreshold != 0 && c.totalSuccessful+c.totalErrors > 40 &&
-		(c.totalSuccessful == 0 || int(100.0/(float64((c.totalSuccessful+c.totalErrors))/float64(c.totalErrors))) > c.Options.ErrorHandling.PercentageThreshold) {
-		exit := true
-		if c.Options.ErrorHandling.VerifyIPBanIfExheeded {
-			exit = c.verifyIpBan(msg)
-		}
-		if exit {
-			if c.Options.ErrorHandling.IpRotateIfExheeded {
-				c.enableIpRotate(msg.Request.URL)
-				return
-			}
-			if c.Options.ErrorHandling.ReportErrorsIfExheeded {
-				gologger.Info().Msg(c.GetErrorSummary())
-			}
-			gologger.Fatal().Msgf("%d errors out of %d requests exceeded %d%% error threshold, exiting.", c.totalErrors, c.totalSuccessful+c.totalErrors, c.Options.ErrorHandling.PercentageThreshold)
-		}
-	}
+	counts := c.recordResult(msg, true)
+	c.checkErrorThresholds(msg, counts)
 
 	gologger.Debug().Msgf("%s %s\n", msg.Request.URL.String(), msg.TransportError)
 }
 
-func (c *HttpClient) handleHttpError(msg *MessageDuplex) {
-	exit := true
+func (c *HttpClient) handleHttpError(msg *MessageDuplex, counts ErrorCounts) {
+	c.checkErrorThresholds(msg, counts)
+}
 
-	if c.Options.ErrorHandling.ConsecutiveThreshold != 0 &&
-		c.consecutiveErrors > c.Options.ErrorHandling.ConsecutiveThreshold {
+// checkErrorThresholds checks the error statistics of the message's host against the thresholds.
+func (c *HttpClient) checkErrorThresholds(msg *MessageDuplex, counts ErrorCounts) {
+	opts := c.Options.ErrorHandling
 
-		if c.Options.ErrorHandling.VerifyIPBanIfExheeded {
-			exit = c.verifyIpBan(msg)
-		}
-		if exit {
-			if c.Options.ErrorHandling.IpRotateIfExheeded {
-				c.enableIpRotate(msg.Request.URL)
-				return
-			}
-			if c.Options.ErrorHandling.ReportErrorsIfExheeded {
-				gologger.Info().Msg(c.GetErrorSummary())
-			}
-			gologger.Fatal().Msgf("Exceeded %d consecutive errors threshold, exiting.", c.Options.ErrorHandling.ConsecutiveThreshold)
+	if opts.ConsecutiveThreshold != 0 && counts.Consecutive > opts.ConsecutiveThreshold {
+		reason := fmt.Sprintf("Exceeded %d consecutive errors threshold", opts.ConsecutiveThreshold)
+		if c.handleThresholdExceeded(msg, reason) {
+			return
 		}
 	}
 
-	if c.Options.ErrorHandling.PercentageThreshold != 0 && c.totalSuccessful+c.totalErrors > 40 &&
-		(c.totalSuccessful == 0 || int(100.0/(float64((c.totalSuccessful+c.totalErrors))/float64(c.totalErrors))) > c.Options.ErrorHandling.PercentageThreshold) {
-
-		if c.Options.ErrorHandling.VerifyIPBanIfExheeded {
-			exit = c.verifyIpBan(msg)
-		}
-		if exit {
-			if c.Options.ErrorHandling.IpRotateIfExheeded {
-				c.enableIpRotate(msg.Request.URL)
-				return
-			}
-			if c.Options.ErrorHandling.ReportErrorsIfExheeded {
-				gologger.Info().Msg(c.GetErrorSummary())
-			}
-			gologger.Fatal().Msgf("%d errors out of %d requests exceeded %d%% error threshold, exiting.", c.totalErrors, c.totalSuccessful+c.totalErrors, c.Options.ErrorHandling.PercentageThreshold)
+	if opts.PercentageThreshold != 0 && counts.WindowTotal() > opts.PercentageMinRequests &&
+		(counts.WindowSuccessful == 0 || counts.WindowPercentage() > opts.PercentageThreshold) &&
+		c.setThresholdExceeded(msg.Request.URL.Host, true) {
+		reason := fmt.Sprintf("%d errors out of %d requests exceeded %d%% error threshold", counts.WindowFailed, counts.WindowTotal(), opts.PercentageThreshold)
+		if !c.handleThresholdExceeded(msg, reason) {
+			c.setThresholdExceeded(msg.Request.URL.Host, false)
 		}
 	}
 }
 
-func (c *HttpClient) verifyIpBan(msg *MessageDuplex) bool {
-	if c.ipBanCheck.Load() {
+// handleThresholdExceeded applies the configured threshold actions to the message's host,
+// it returns false if the errors turned out not to be caused by an ip ban.
+func (c *HttpClient) handleThresholdExceeded(msg *MessageDuplex, reason string) bool {
+	opts := c.Options.ErrorHandling
+
+	c.Events.thresholdExceeded(msg, reason)
+
+	if opts.VerifyIPBanIfExheeded && !c.verifyIpBan(msg) {
 		return false
 	}
-	defer c.ipBanCheck.Store(false)
-	c.ipBanCheck.Store(true)
+	banned := opts.VerifyIPBanIfExheeded
 
-	gologger.Warning().Msg("Potential IP ban detected, verifying..")
-
-	messages := c.MessageLog.Search(func(e *MessageDuplex) bool {
-		if msg.Response == nil {
-			return e.TransportError != msg.TransportError && e.Request != nil
-		} else {
-			return e.Response != nil && e.Response.StatusCode != msg.Response.StatusCode && e.Request != nil
+	if opts.IpRotateIfExheeded {
+		err := c.enableIpRotate(msg.Request.URL)
+		if err == nil {
+			return true
 		}
-	})
-
-	if len(messages) == 0 {
-		messages = c.MessageLog
+		if !banned || opts.BanCooldown <= 0 {
+			c.haltHost(msg.Request.URL.Host, err)
+			return true
+		}
+		gologger.Warning().Msg(err.Error())
 	}
 
-	req := messages[0].Request.Clone(c.context)
-
-	opts := c.Options
-	opts.RequestPriority = 1000
-	opts.Performance.ReplayRateLimitted = false
-	newMsg := c.SendWithOptions(req, opts)
-
-	c.ThreadPool.lockedThreads <- true
-	<-newMsg.Resolved
-	<-c.ThreadPool.lockedThreads
+	if opts.ReportErrorsIfExheeded {
+		gologger.Info().Msg(c.GetErrorSummary())
+	}
 
-	if msg.TransportError != NoError && newMsg.TransportError != msg.TransportError {
-		gologger.Warning().Msg("No IP ban, continuing..")
-		return false
-	} else if msg.TransportError == NoError && newMsg.Response != nil && newMsg.Response.Status != msg.Response.Status {
-		gologger.Warning().Msg("No IP ban, continuing..")
-		return false
+	if opts.PauseIfExheeded {
+		gologger.Warning().Msgf("%s, pausing.", reason)
+		c.Pause()
+		return true
 	}
 
-	gologger.Warning().Msg("IP ban detected, exiting.")
+	if banned && opts.BanCooldown > 0 {
+		gologger.Warning().Msgf("%s, cooling down %s for %s.", reason, msg.Request.URL.Host, opts.BanCooldown)
+		c.coolDown(msg)
+		return true
+	}
 
+	c.haltHost(msg.Request.URL.Host, &ThresholdExceededError{Host: msg.Request.URL.Host, Reason: reason})
 	return true
 }
 
+// GetErrorSummary describes the error counts by status code and transport error,
+// it is built from the statistics on every call.
 func (c *HttpClient) GetErrorSummary() string {
-	timeouts := c.MessageLog.Search(func(e *MessageDuplex) bool {
-		return e.TransportError == Timeout
-	})
-
-	connectionReset := c.MessageLog.Search(func(e *MessageDuplex) bool {
-		return e.TransportError == ConnectionReset
-	})
-
-	generalTransportError := c.MessageLog.Search(func(e *MessageDuplex) bool {
-		return e.TransportError == UnknownError
-	})
-
-	httpErrors := c.MessageLog.Search(func(e *MessageDuplex) bool {
-		return e.Response != nil && e.Response.StatusCode >= 400 && c.Options.ErrorHandling.Matches(e.Response.StatusCode)
-	})
-
-	groupedHttpErrors := map[int]int{}
-	for _, errorResponse := range httpErrors {
-		groupedHttpErrors[errorResponse.Response.StatusCode] += 1
-	}
-
-	errorPercentage := int(100.0 / (float64((c.totalSuccessful + c.totalErrors)) / float64(c.totalErrors)))
-
+	c.stats.mutex.Lock()
 	errorTypes := []string{}
-	for status, count := range groupedHttpErrors {
-		errorTypes = append(errorTypes, fmt.Sprintf("%d: %d", status, count))
-	}
-	if len(timeouts) != 0 {
-		errorTypes = append(errorTypes, fmt.Sprintf("Timeouts: %d", len(timeouts)))
-	}
-	if len(connectionReset) != 0 {
-		errorTypes = append(errorTypes, fmt.Sprintf("ConnectionReset: %d", len(connectionReset)))
+	for status, count := range c.stats.statusCodes {
+		if status >= 400 && c.Options.ErrorHandling.Matches(status) {
+			errorTypes = append(errorTypes, fmt.Sprintf("%d: %d", status, count))
+		}
 	}
-	if len(generalTransportError) != 0 {
-		errorTypes = append(errorTypes, fmt.Sprintf("GenericTransportError: %d", len(generalTransportError)))
+	for kind := range transportErrorNames {
+		count := c.stats.transportErrors[TransportError(kind)]
+		switch {
+		case count == 0, TransportError(kind) == Cancelled, TransportError(kind) == Halted, TransportError(kind) == CircuitOpen:
+		case TransportError(kind) == Timeout:
+			errorTypes = append(errorTypes, fmt.Sprintf("Timeouts: %d", count))
+		case TransportError(kind) == UnknownError:
+			errorTypes = append(errorTypes, fmt.Sprintf("GenericTransportError: %d", count))
+		default:
+			errorTypes = append(errorTypes, fmt.Sprintf("%s: %d", TransportError(kind), count))
+		}
 	}
+	c.stats.mutex.Unlock()
+
+	counts := c.ErrorCounts()
+
 	return fmt.Sprintf("successful: %d, failed: %d, consecutive errors: %d, percentage: %d%% (%s)\n",
-		c.totalSuccessful, c.totalErrors, c.consecutiveErrors, errorPercentage, strings.Join(errorTypes[:], ","))
+		counts.Successful, counts.Failed, counts.Consecutive, counts.Percentage(), strings.Join(errorTypes[:], ","))
 }
 
 // func isRSTError(err error) bool {
@@ -0,0 +1,125 @@
+package httpc
+
+// EventHooks are callbacks invoked at the key points of a request's lifecycle,
+// they are called synchronously and possibly concurrently from the client's
+// goroutines, so they should be safe for concurrent use and return quickly.
+type EventHooks struct {
+	// OnQueued is called when a request, including retries and redirects, is queued
+	OnQueued func(msg *MessageDuplex)
+	// OnSent is called when a request is dispatched
+	OnSent func(msg *MessageDuplex)
+	// OnResponse is called when a response is received and processed by the response middlewares
+	OnResponse func(msg *MessageDuplex)
+	// OnError is called when a request fails with a transport error other than Cancelled
+	OnError func(msg *MessageDuplex, err error)
+	// OnRetry is called when a request that failed is queued again
+	OnRetry func(msg *MessageDuplex, attempt int)
+	// OnRedirect is called when a redirect is followed
+	OnRedirect func(from *MessageDuplex, to *MessageDuplex)
+	// OnRateChange is called when the desired or throttled request rate changes
+	OnRateChange func(event RateChangeEvent)
+	// OnIpBanVerified is called with the result of an ip ban verification
+	OnIpBanVerified func(msg *MessageDuplex, canary *MessageDuplex, banned bool)
+	// OnThresholdExceeded is called when an error threshold is exceeded, before any threshold action is taken
+	OnThresholdExceeded func(msg *MessageDuplex, reason string)
+	// OnRecovered is called when a host that exceeded the percentage threshold drops below the recovery percentage
+	OnRecovered func(host string)
+	// OnCircuitChange is called when the state of a host's circuit changes
+	OnCircuitChange func(host string, state BreakerState)
+	// OnHostPaused and OnHostResumed are called when a host is paused, e.g. during an ip ban cooldown, and resumed
+	OnHostPaused  func(host string)
+	OnHostResumed func(host string)
+	// OnHalted is called when the client, or a single host if host is not empty, is halted
+	OnHalted func(host string, err error)
+}
+
+type RateChangeEvent struct {
+	// RequestsPerSecond is the desired rate, zero means unlimited
+	RequestsPerSecond float64
+	// ThrottleRate is how much the desired rate is reduced by due to rate limiting responses
+	ThrottleRate float64
+}
+
+func (h *EventHooks) queued(msg *MessageDuplex) {
+	if h.OnQueued != nil {
+		h.OnQueued(msg)
+	}
+}
+
+func (h *EventHooks) sent(msg *MessageDuplex) {
+	if h.OnSent != nil {
+		h.OnSent(msg)
+	}
+}
+
+func (h *EventHooks) response(msg *MessageDuplex) {
+	if h.OnResponse != nil {
+		h.OnResponse(msg)
+	}
+}
+
+func (h *EventHooks) error(msg *MessageDuplex, err error) {
+	if h.OnError != nil {
+		h.OnError(msg, err)
+	}
+}
+
+func (h *EventHooks) retry(msg *MessageDuplex, attempt int) {
+	if h.OnRetry != nil {
+		h.OnRetry(msg, attempt)
+	}
+}
+
+func (h *EventHooks) redirect(from *MessageDuplex, to *MessageDuplex) {
+	if h.OnRedirect != nil {
+		h.OnRedirect(from, to)
+	}
+}
+
+func (h *EventHooks) rateChange(event RateChangeEvent) {
+	if h.OnRateChange != nil {
+		h.OnRateChange(event)
+	}
+}
+
+func (h *EventHooks) ipBanVerified(msg *MessageDuplex, canary *MessageDuplex, banned bool) {
+	if h.OnIpBanVerified != nil {
+		h.OnIpBanVerified(msg, canary, banned)
+	}
+}
+
+func (h *EventHooks) thresholdExceeded(msg *MessageDuplex, reason string) {
+	if h.OnThresholdExceeded != nil {
+		h.OnThresholdExceeded(msg, reason)
+	}
+}
+
+func (h *EventHooks) halted(host string, err error) {
+	if h.OnHalted != nil {
+		h.OnHalted(host, err)
+	}
+}
+
+func (h *EventHooks) recovered(host string) {
+	if h.OnRecovered != nil {
+		h.OnRecovered(host)
+	}
+}
+
+func (h *EventHooks) circuitChange(host string, state BreakerState) {
+	if h.OnCircuitChange != nil {
+		h.OnCircuitChange(host, state)
+	}
+}
+
+func (h *EventHooks) hostPaused(host string) {
+	if h.OnHostPaused != nil {
+		h.OnHostPaused(host)
+	}
+}
+
+func (h *EventHooks) hostResumed(host string) {
+	if h.OnHostResumed != nil {
+		h.OnHostResumed(host)
+	}
+}
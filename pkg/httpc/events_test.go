@@ -0,0 +1,143 @@
+package httpc_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aristosMiliaressis/httpc/pkg/httpc"
+	"github.com/aristosMiliaressis/httpc/pkg/httpctest"
+)
+
+// eventLog records the events delivered to every hook of a client, in order.
+type eventLog struct {
+	mutex  sync.Mutex
+	events []string
+}
+
+func (l *eventLog) add(format string, args ...any) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.events = append(l.events, fmt.Sprintf(format, args...))
+}
+
+func (l *eventLog) String() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return strings.Join(l.events, ", ")
+}
+
+func (l *eventLog) hooks() httpc.EventHooks {
+	return httpc.EventHooks{
+		OnQueued:   func(msg *httpc.MessageDuplex) { l.add("queued %s", msg.Request.URL.Path) },
+		OnSent:     func(msg *httpc.MessageDuplex) { l.add("sent %s", msg.Request.URL.Path) },
+		OnResponse: func(msg *httpc.MessageDuplex) { l.add("response %s", msg.Request.URL.Path) },
+		OnError:    func(msg *httpc.MessageDuplex, err error) { l.add("error %s", msg.TransportError) },
+		OnRetry:    func(msg *httpc.MessageDuplex, attempt int) { l.add("retry %d", attempt) },
+		OnRedirect: func(from *httpc.MessageDuplex, to *httpc.MessageDuplex) {
+			l.add("redirect %s %s", from.Request.URL.Path, to.Request.URL.Path)
+		},
+		OnRateChange:        func(event httpc.RateChangeEvent) { l.add("rate %.0f", event.RequestsPerSecond) },
+		OnThresholdExceeded: func(msg *httpc.MessageDuplex, reason string) { l.add("threshold") },
+		OnCircuitChange:     func(host string, state httpc.BreakerState) { l.add("circuit %s", state) },
+		OnHostPaused:        func(host string) { l.add("paused") },
+		OnHostResumed:       func(host string) { l.add("resumed") },
+		OnHalted:            func(host string, err error) { l.add("halted %v", host != "") },
+	}
+}
+
+// OnRecovered and OnIpBanVerified are covered by the recovery hysteresis and ip ban tests.
+func TestEventHooks(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(opts *httpc.ClientOptions)
+		run       func(t *testing.T, c *httpc.HttpClient, srv *httpctest.Server)
+		want      string
+	}{
+		{
+			name: "response",
+			run: func(t *testing.T, c *httpc.HttpClient, srv *httpctest.Server) {
+				httpctest.Send(t, c, "GET", srv.URL+"/ok", "")
+			},
+			want: "queued /ok, sent /ok, response /ok",
+		},
+		{
+			name: "error and retry",
+			configure: func(opts *httpc.ClientOptions) {
+				opts.ErrorHandling.RetryTransportFailures = true
+				opts.ErrorHandling.MaxRetries = 1
+			},
+			run: func(t *testing.T, c *httpc.HttpClient, srv *httpctest.Server) {
+				httpctest.Send(t, c, "GET", srv.URL+"/reset", "")
+			},
+			want: "queued /reset, sent /reset, error ConnectionReset, retry 1, queued /reset, sent /reset, error ConnectionReset",
+		},
+		{
+			name: "redirect",
+			run: func(t *testing.T, c *httpc.HttpClient, srv *httpctest.Server) {
+				httpctest.Send(t, c, "GET", srv.URL+"/old", "")
+			},
+			want: "queued /old, sent /old, response /old, redirect /old /ok, queued /ok, sent /ok, response /ok",
+		},
+		{
+			name: "rate change",
+			run: func(t *testing.T, c *httpc.HttpClient, srv *httpctest.Server) {
+				c.ThreadPool.ChangeRate(10)
+			},
+			want: "rate 10",
+		},
+		{
+			name: "threshold exceeded and halted",
+			configure: func(opts *httpc.ClientOptions) {
+				opts.ErrorHandling.ConsecutiveThreshold = 1
+			},
+			run: func(t *testing.T, c *httpc.HttpClient, srv *httpctest.Server) {
+				httpctest.Send(t, c, "GET", srv.URL+"/reset", "")
+				httpctest.Send(t, c, "GET", srv.URL+"/reset", "")
+			},
+			// the thresholds are checked before the error is reported
+			want: "queued /reset, sent /reset, error ConnectionReset, queued /reset, sent /reset, threshold, halted true, error ConnectionReset",
+		},
+		{
+			name: "circuit change",
+			configure: func(opts *httpc.ClientOptions) {
+				opts.ErrorHandling.CircuitBreaker.FailureThreshold = 1
+			},
+			run: func(t *testing.T, c *httpc.HttpClient, srv *httpctest.Server) {
+				httpctest.Send(t, c, "GET", srv.URL+"/reset", "")
+			},
+			want: "queued /reset, sent /reset, error ConnectionReset, circuit Open",
+		},
+		{
+			name: "host paused and resumed",
+			run: func(t *testing.T, c *httpc.HttpClient, srv *httpctest.Server) {
+				c.PauseHost("a.test")
+				c.ResumeHost("a.test")
+			},
+			want: "paused, resumed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httpctest.NewServer().Script(
+				httpctest.Rule{Path: "/reset", Behavior: httpctest.Reset()},
+				httpctest.Rule{Path: "/old", Behavior: httpctest.Redirect("/ok", http.StatusFound)},
+			)
+			defer srv.Close()
+
+			c := httpctest.NewClient(t, tt.configure)
+			log := &eventLog{}
+			c.Events = log.hooks()
+
+			tt.run(t, c, srv)
+			if got := log.String(); got != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package httpc
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type FixtureMode int
+
+const (
+	FixturesDisabled FixtureMode = iota
+	// FixturesRecord sends requests as usual and saves them with their responses
+	FixturesRecord
+	// FixturesReplay serves responses from the fixture file instead of sending requests
+	FixturesReplay
+)
+
+// FixtureKey returns the key a request is matched to fixtures on.
+type FixtureKey func(req *http.Request, body []byte) string
+
+var ErrFixtureNotFound = errors.New("no fixture matches request")
+
+// DefaultFixtureKey matches requests on their method, url and body,
+// ignoring the given query parameters.
+func DefaultFixtureKey(ignoreParams ...string) FixtureKey {
+	return func(req *http.Request, body []byte) string {
+		u := *req.URL
+		query := u.Query()
+		for _, param := range ignoreParams {
+			query.Del(param)
+		}
+		u.RawQuery = query.Encode()
+		u.Fragment = ""
+
+		key := req.Method + " " + u.String()
+		if len(body) > 0 {
+			sum := sha256.Sum256(body)
+			key += " " + hex.EncodeToString(sum[:])
+		}
+
+		return key
+	}
+}
+
+// Fixtures records request/response pairs or serves responses from previously recorded ones,
+// it is used in place of the transport so requests still go through the client's queue and middlewares.
+type Fixtures struct {
+	mutex sync.Mutex
+	opts  FixtureOptions
+	key   FixtureKey
+
+	entries []fixture
+	served  map[string]int
+	// loadErr is why the fixture file couldn't be loaded, every replayed request fails with it
+	loadErr error
+}
+
+type fixture struct {
+	Key            string
+	TransportError TransportError
+	Code           int    `json:",omitempty"`
+	CodeName       string `json:",omitempty"`
+	Error          string `json:",omitempty"`
+	Url            string
+	Request        []byte
+	Response       []byte `json:",omitempty"`
+}
+
+// NewFixtures creates the fixtures for the given mode, loading the fixture file when replaying.
+func NewFixtures(opts FixtureOptions) (*Fixtures, error) {
+	f := &Fixtures{opts: opts, key: opts.Key, served: map[string]int{}}
+	if f.key == nil {
+		f.key = DefaultFixtureKey(opts.IgnoreParams...)
+	}
+
+	if opts.Mode != FixturesReplay {
+		return f, nil
+	}
+
+	data, err := os.ReadFile(opts.Path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &f.entries); err != nil {
+		return nil, fmt.Errorf("failed to decode fixtures %s: %w", opts.Path, err)
+	}
+
+	return f, nil
+}
+
+// missingFixtures replaces fixtures that failed to load, so that replayed requests fail instead of reaching the network.
+func missingFixtures(opts FixtureOptions, err error) *Fixtures {
+	return &Fixtures{opts: opts, served: map[string]int{}, loadErr: err}
+}
+
+// Save writes the recorded fixtures to the fixture file.
+func (f *Fixtures) Save() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.opts.Mode != FixturesRecord {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(f.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(f.opts.Path, data, 0644)
+}
+
+// Len returns the number of fixtures.
+func (f *Fixtures) Len() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return len(f.entries)
+}
+
+// Keys returns the distinct keys of the fixtures, sorted.
+func (f *Fixtures) Keys() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	seen := map[string]bool{}
+	keys := []string{}
+	for _, entry := range f.entries {
+		if !seen[entry.Key] {
+			seen[entry.Key] = true
+			keys = append(keys, entry.Key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func (f *Fixtures) requestKey(uow PendingRequest) string {
+	req := uow.Message.Request
+	if uow.RawRequest != "" {
+		if raw, err := ParseRawRequest([]byte(uow.RawRequest), req.URL.String()); err == nil {
+			req = raw
+		}
+	}
+
+	var body []byte
+	if req.GetBody != nil {
+		if reader, err := req.GetBody(); err == nil {
+			body, _ = io.ReadAll(reader)
+			reader.Close()
+		}
+	}
+
+	return f.key(req, body)
+}
+
+// replay returns the response recorded for the request, requests recorded more than once
+// are served in the order they were recorded with the last one repeating.
+func (f *Fixtures) replay(uow PendingRequest) (*http.Response, error) {
+	if err := uow.Message.Request.Context().Err(); err != nil {
+		return nil, err
+	}
+
+	if f.loadErr != nil {
+		return nil, &TransportFailure{Kind: FixtureMissing, Err: f.loadErr}
+	}
+
+	key := f.requestKey(uow)
+
+	f.mutex.Lock()
+	matches := []fixture{}
+	for _, entry := range f.entries {
+		if entry.Key == key {
+			matches = append(matches, entry)
+		}
+	}
+	if len(matches) == 0 {
+		f.mutex.Unlock()
+		return nil, &TransportFailure{Kind: FixtureMissing, Err: fmt.Errorf("%w: %s", ErrFixtureNotFound, key)}
+	}
+	entry := matches[min(f.served[key], len(matches)-1)]
+	f.served[key]++
+	f.mutex.Unlock()
+
+	if entry.TransportError != NoError {
+		return nil, &TransportFailure{Kind: entry.TransportError, Code: entry.Code, CodeName: entry.CodeName, Err: errors.New(entry.Error)}
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(entry.Response)), uow.Message.Request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture response: %w", err)
+	}
+
+	return resp, nil
+}
+
+// record saves the outcome of sending a request.
+func (f *Fixtures) record(uow PendingRequest, sendErr error) error {
+	entry := fixture{
+		Key:     f.requestKey(uow),
+		Url:     uow.Message.Request.URL.String(),
+		Request: []byte(uow.RawRequest),
+	}
+	if uow.RawRequest == "" {
+		var err error
+		if entry.Request, err = dumpRequest(uow.Message.Request); err != nil {
+			return err
+		}
+	}
+
+	if sendErr != nil {
+		failure := classifyTransportError(sendErr)
+		if failure.Kind == Cancelled {
+			return nil
+		}
+		entry.TransportError = failure.Kind
+		entry.Code = failure.Code
+		entry.CodeName = failure.CodeName
+		entry.Error = strings.TrimPrefix(failure.Err.Error(), failure.Kind.String()+": ")
+	} else {
+		var err error
+		if entry.Response, err = dumpResponse(uow.Message); err != nil {
+			return err
+		}
+	}
+
+	f.mutex.Lock()
+	f.entries = append(f.entries, entry)
+	f.mutex.Unlock()
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package httpc_test
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aristosMiliaressis/httpc/pkg/httpc"
+	"github.com/aristosMiliaressis/httpc/pkg/httpctest"
+)
+
+func fixtureOptions(mode httpc.FixtureMode, path string) func(opts *httpc.ClientOptions) {
+	return func(opts *httpc.ClientOptions) {
+		opts.Fixtures.Mode = mode
+		opts.Fixtures.Path = path
+		opts.CacheBusting.QueryParam = httpc.DefaultCacheBusterParam
+	}
+}
+
+func TestFixturesRecordAndReplay(t *testing.T) {
+	srv := httpctest.NewServer().Script(
+		httpctest.Rule{Path: "/counter", Behavior: httpctest.Sequence(
+			httpctest.Respond(200, nil, "first"),
+			httpctest.Respond(200, nil, "second"),
+		)},
+		httpctest.Rule{Path: "/reset", Behavior: httpctest.Reset()},
+		httpctest.Rule{Path: "/echo", Behavior: httpctest.Respond(201, http.Header{"X-Test": {"1"}}, "created")},
+	)
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "fixtures.json")
+
+	c := httpctest.NewClient(t, fixtureOptions(httpc.FixturesRecord, path))
+	httpctest.Send(t, c, "GET", srv.URL+"/counter", "")
+	httpctest.Send(t, c, "GET", srv.URL+"/counter", "")
+	httpctest.Send(t, c, "GET", srv.URL+"/reset", "")
+	httpctest.Send(t, c, "POST", srv.URL+"/echo", "a")
+	c.Close()
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected the fixtures to be saved on Close: %s", err)
+	}
+	httpctest.AssertServerRequests(t, srv, 4)
+	srv.Reset()
+
+	// the cache buster differs between runs and is left out of the key
+	c = httpctest.NewClient(t, fixtureOptions(httpc.FixturesReplay, path))
+	if c.Fixtures.Len() != 4 || len(c.Fixtures.Keys()) != 3 {
+		t.Fatalf("expected 4 fixtures with 3 keys, got %d and %v", c.Fixtures.Len(), c.Fixtures.Keys())
+	}
+
+	for _, want := range []string{"first", "second", "second"} {
+		if msg := httpctest.Send(t, c, "GET", srv.URL+"/counter", ""); msg.ResponseBody.String() != want {
+			t.Fatalf("expected repeated requests to be served in recording order, got %q instead of %q", msg.ResponseBody.String(), want)
+		}
+	}
+
+	if msg := httpctest.Send(t, c, "GET", srv.URL+"/reset", ""); msg.TransportError != httpc.ConnectionReset {
+		t.Fatalf("expected the recorded transport error to be replayed, got %s", msg.TransportError)
+	}
+
+	msg := httpctest.Send(t, c, "POST", srv.URL+"/echo", "a")
+	if msg.Response == nil || msg.Response.StatusCode != 201 || msg.Response.Header.Get("X-Test") != "1" || msg.ResponseBody.String() != "created" {
+		t.Fatalf("expected the recorded response to be replayed, got %s", msg.TransportError)
+	}
+
+	// a different body is a different request
+	if msg := httpctest.Send(t, c, "POST", srv.URL+"/echo", "b"); msg.TransportError != httpc.FixtureMissing {
+		t.Fatalf("expected FixtureMissing for an unrecorded body, got %s", msg.TransportError)
+	}
+
+	httpctest.AssertServerRequests(t, srv, 0)
+}
+
+func TestFixturesReplayStaysOffline(t *testing.T) {
+	srv := httpctest.NewServer()
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "fixtures.json")
+	if err := os.WriteFile(path, []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := httpctest.NewClient(t, fixtureOptions(httpc.FixturesReplay, path))
+	msg := httpctest.Send(t, c, "GET", srv.URL+"/unknown", "")
+	if msg.TransportError != httpc.FixtureMissing {
+		t.Fatalf("expected FixtureMissing for an unrecorded request, got %s", msg.TransportError)
+	}
+	var failure *httpc.TransportFailure
+	if !errors.As(msg.Error, &failure) || !errors.Is(failure, httpc.ErrFixtureNotFound) {
+		t.Fatalf("expected the failure to wrap ErrFixtureNotFound, got %v", msg.Error)
+	}
+
+	// a fixture file that can't be loaded fails every request instead of sending them
+	c = httpctest.NewClient(t, fixtureOptions(httpc.FixturesReplay, filepath.Join(t.TempDir(), "missing.json")))
+	if msg := httpctest.Send(t, c, "GET", srv.URL+"/unknown", ""); msg.TransportError != httpc.FixtureMissing {
+		t.Fatalf("expected FixtureMissing without a fixture file, got %s", msg.TransportError)
+	}
+
+	httpctest.AssertServerRequests(t, srv, 0)
+}
+
+func TestDefaultFixtureKey(t *testing.T) {
+	key := httpc.DefaultFixtureKey("cb")
+
+	a, _ := http.NewRequest("GET", "http://a.test/path?x=1&cb=123#top", nil)
+	b, _ := http.NewRequest("GET", "http://a.test/path?cb=456&x=1", nil)
+	if key(a, nil) != key(b, nil) {
+		t.Fatalf("expected ignored params and fragments not to change the key, got %q and %q", key(a, nil), key(b, nil))
+	}
+
+	c, _ := http.NewRequest("GET", "http://a.test/path?x=2", nil)
+	if key(a, nil) == key(c, nil) {
+		t.Fatal("expected other params to change the key")
+	}
+	if key(a, nil) == key(a, []byte("body")) {
+		t.Fatal("expected the body to change the key")
+	}
+	post, _ := http.NewRequest("POST", "http://a.test/path?x=1", nil)
+	if key(a, nil) == key(post, nil) {
+		t.Fatal("expected the method to change the key")
+	}
+}
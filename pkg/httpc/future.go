@@ -0,0 +1,119 @@
+package httpc
+
+import (
+	"context"
+	"sync"
+)
+
+// Future is the result handle of a sent request, it is completed exactly once
+// with the final message after all retries and redirects have finished.
+type Future struct {
+	done      chan struct{}
+	mutex     sync.Mutex
+	message   *MessageDuplex
+	callbacks []func(msg *MessageDuplex)
+	// running is set while a goroutine is delivering the queued callbacks
+	running bool
+}
+
+func newFuture() *Future {
+	return &Future{
+		done: make(chan struct{}),
+	}
+}
+
+// Done returns a channel that is closed once the request has completed.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until the request has completed or the context is done.
+func (f *Future) Wait(ctx context.Context) (*MessageDuplex, error) {
+	select {
+	case <-f.done:
+		return f.message, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Message returns the final message or nil if the request has not completed yet.
+func (f *Future) Message() *MessageDuplex {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.message
+}
+
+// OnComplete registers a callback that is called with the final message, callbacks run
+// one at a time in registration order on a separate goroutine, including ones registered after completion.
+func (f *Future) OnComplete(callback func(msg *MessageDuplex)) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.callbacks = append(f.callbacks, callback)
+	if f.message != nil {
+		f.runCallbacks()
+	}
+}
+
+// runCallbacks starts delivering the queued callbacks unless that is already happening.
+// Must be called with the mutex held after completion.
+func (f *Future) runCallbacks() {
+	if f.running || len(f.callbacks) == 0 {
+		return
+	}
+	f.running = true
+
+	go func() {
+		for {
+			f.mutex.Lock()
+			if len(f.callbacks) == 0 {
+				f.running = false
+				f.mutex.Unlock()
+				return
+			}
+			callback := f.callbacks[0]
+			f.callbacks[0] = nil
+			f.callbacks = f.callbacks[1:]
+			msg := f.message
+			f.mutex.Unlock()
+
+			callback(msg)
+		}
+	}()
+}
+
+// Then chains a follow-up request on completion, the returned future completes
+// with the follow-up's message or with this message if next returns nil.
+func (f *Future) Then(next func(msg *MessageDuplex) *Future) *Future {
+	chained := newFuture()
+
+	f.OnComplete(func(msg *MessageDuplex) {
+		nextFuture := next(msg)
+		if nextFuture == nil {
+			chained.complete(msg)
+			return
+		}
+
+		nextFuture.OnComplete(func(nextMsg *MessageDuplex) {
+			chained.complete(nextMsg)
+		})
+	})
+
+	return chained
+}
+
+func (f *Future) complete(msg *MessageDuplex) bool {
+	f.mutex.Lock()
+	if f.message != nil {
+		f.mutex.Unlock()
+		return false
+	}
+	f.message = msg
+	close(f.done)
+	f.runCallbacks()
+	f.mutex.Unlock()
+
+	return true
+}
@@ -0,0 +1,138 @@
+package httpc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFutureCompletesOnce(t *testing.T) {
+	f := newFuture()
+	first, second := &MessageDuplex{}, &MessageDuplex{}
+
+	if f.Message() != nil {
+		t.Fatal("expected no message before completion")
+	}
+	if !f.complete(first) {
+		t.Fatal("expected the first completion to succeed")
+	}
+	if f.complete(second) {
+		t.Fatal("expected the second completion to be ignored")
+	}
+
+	if msg := waitFuture(t, f); msg != first {
+		t.Fatal("expected the future to keep the first message")
+	}
+	if f.Message() != first {
+		t.Fatal("expected Message to return the first message")
+	}
+}
+
+func TestFutureWaitCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := newFuture().Wait(ctx); err != context.Canceled {
+		t.Fatalf("expected the wait to be cancelled, got %v", err)
+	}
+}
+
+func TestFutureCallbackOrder(t *testing.T) {
+	f := newFuture()
+
+	var mutex sync.Mutex
+	order := []int{}
+	running, overlapped := 0, false
+	done := make(chan struct{})
+
+	register := func(i int) {
+		f.OnComplete(func(msg *MessageDuplex) {
+			mutex.Lock()
+			running++
+			overlapped = overlapped || running > 1
+			order = append(order, i)
+			mutex.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mutex.Lock()
+			running--
+			mutex.Unlock()
+
+			if i == 9 {
+				close(done)
+			}
+		})
+	}
+
+	for i := 0; i < 5; i++ {
+		register(i)
+	}
+	f.complete(&MessageDuplex{})
+	// callbacks registered after completion are queued behind the pending ones
+	for i := 5; i < 10; i++ {
+		register(i)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("callbacks did not run")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if overlapped {
+		t.Error("expected callbacks to run one at a time")
+	}
+	for i := range order {
+		if order[i] != i {
+			t.Fatalf("expected callbacks in registration order, got %v", order)
+		}
+	}
+}
+
+func TestFutureCallbackRegisteredFromCallback(t *testing.T) {
+	f := newFuture()
+	f.complete(&MessageDuplex{})
+
+	done := make(chan struct{})
+	f.OnComplete(func(msg *MessageDuplex) {
+		f.OnComplete(func(msg *MessageDuplex) {
+			close(done)
+		})
+	})
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback registered by a callback did not run")
+	}
+}
+
+func TestFutureThen(t *testing.T) {
+	first, followUp := &MessageDuplex{}, &MessageDuplex{}
+
+	f := newFuture()
+	next := newFuture()
+	chained := f.Then(func(msg *MessageDuplex) *Future {
+		if msg != first {
+			t.Error("expected Then to be called with the first message")
+		}
+		return next
+	})
+
+	f.complete(first)
+	next.complete(followUp)
+	if msg := waitFuture(t, chained); msg != followUp {
+		t.Fatal("expected the chained future to complete with the follow-up message")
+	}
+
+	f = newFuture()
+	chained = f.Then(func(msg *MessageDuplex) *Future { return nil })
+	f.complete(first)
+	if msg := waitFuture(t, chained); msg != first {
+		t.Fatal("expected the chained future to complete with the first message without a follow-up")
+	}
+}
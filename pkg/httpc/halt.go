@@ -0,0 +1,172 @@
+package httpc
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/projectdiscovery/gologger"
+)
+
+// ErrHalted is matched by every error a client or host is halted with.
+var ErrHalted = errors.New("halted")
+
+// ThresholdExceededError is the error a client or host is halted with
+// when one of the error thresholds is exceeded.
+type ThresholdExceededError struct {
+	Host   string
+	Reason string
+}
+
+func (e *ThresholdExceededError) Error() string {
+	if e.Host == "" {
+		return e.Reason
+	}
+
+	return fmt.Sprintf("%s: %s", e.Host, e.Reason)
+}
+
+func (e *ThresholdExceededError) Is(target error) bool {
+	return target == ErrHalted
+}
+
+// IpRotateError is the error a host is halted with when
+// an api gateway for ip rotation could not be created.
+type IpRotateError struct {
+	BaseUrl string
+	Err     error
+}
+
+func (e *IpRotateError) Error() string {
+	return fmt.Sprintf("error while creating api gateway for ip rotation of %s: %s", e.BaseUrl, e.Err)
+}
+
+func (e *IpRotateError) Unwrap() error {
+	return e.Err
+}
+
+func (e *IpRotateError) Is(target error) bool {
+	return target == ErrHalted
+}
+
+// Halted returns the error the client was halted with or nil if it is not halted.
+// While halted every request fails with the Halted transport error until Reset is called.
+func (c *HttpClient) Halted() error {
+	c.haltMutex.Lock()
+	defer c.haltMutex.Unlock()
+
+	return c.haltErr
+}
+
+// HostHalted returns the error a host was halted with or nil if it is not halted.
+func (c *HttpClient) HostHalted(host string) error {
+	c.haltMutex.Lock()
+	defer c.haltMutex.Unlock()
+
+	return c.haltedHosts[host]
+}
+
+// HaltedHosts returns the halted hosts along with the error each one was halted with.
+func (c *HttpClient) HaltedHosts() map[string]error {
+	c.haltMutex.Lock()
+	defer c.haltMutex.Unlock()
+
+	hosts := make(map[string]error, len(c.haltedHosts))
+	for host, err := range c.haltedHosts {
+		hosts[host] = err
+	}
+
+	return hosts
+}
+
+// Reset takes the client and every host out of the halted state, resets the error counts and closes every circuit.
+func (c *HttpClient) Reset() {
+	c.haltMutex.Lock()
+	c.haltErr = nil
+	c.haltedHosts = map[string]error{}
+	c.haltMutex.Unlock()
+
+	c.breaker.resetAll()
+
+	c.errorMutex.Lock()
+	c.errorStats = newErrorStats(c.Options.ErrorHandling)
+	c.hostErrorStats = map[string]*errorStats{}
+	c.errorMutex.Unlock()
+}
+
+// ResetHost takes a host out of the halted state, resets its error counts and closes its circuit.
+func (c *HttpClient) ResetHost(host string) {
+	c.haltMutex.Lock()
+	delete(c.haltedHosts, host)
+	c.haltMutex.Unlock()
+
+	c.errorMutex.Lock()
+	delete(c.hostErrorStats, host)
+	c.errorMutex.Unlock()
+
+	c.breaker.reset(host)
+}
+
+// Halt puts the client in the halted state and fails every queued request,
+// hosts are halted on their own when they exceed the error thresholds.
+func (c *HttpClient) Halt(err error) {
+	if err == nil {
+		err = ErrHalted
+	}
+
+	c.haltMutex.Lock()
+	if c.haltErr != nil {
+		c.haltMutex.Unlock()
+		return
+	}
+	c.haltErr = err
+	c.haltMutex.Unlock()
+
+	gologger.Warning().Msgf("%s, halting.", err)
+	c.Events.halted("", err)
+
+	for _, uow := range c.ThreadPool.removeWhere(func(uow PendingRequest) bool { return !uow.nested }) {
+		c.handleHalted(uow, err)
+	}
+}
+
+// haltHost puts a host in the halted state and fails its queued requests.
+func (c *HttpClient) haltHost(host string, err error) {
+	c.haltMutex.Lock()
+	if _, ok := c.haltedHosts[host]; ok {
+		c.haltMutex.Unlock()
+		return
+	}
+	c.haltedHosts[host] = err
+	c.haltMutex.Unlock()
+
+	gologger.Warning().Msgf("%s, halting %s.", err, host)
+	c.Events.halted(host, err)
+
+	for _, uow := range c.ThreadPool.removeWhere(func(uow PendingRequest) bool { return !uow.nested && uow.host == host }) {
+		c.handleHalted(uow, err)
+	}
+}
+
+// haltedError returns the error the client or the request's host is halted with, if any,
+// nested requests such as ip ban verification canaries are always sent.
+func (c *HttpClient) haltedError(uow PendingRequest) error {
+	if uow.nested {
+		return nil
+	}
+
+	c.haltMutex.Lock()
+	defer c.haltMutex.Unlock()
+
+	if c.haltErr != nil {
+		return c.haltErr
+	}
+
+	return c.haltedHosts[uow.Message.Request.URL.Host]
+}
+
+func (c *HttpClient) handleHalted(uow PendingRequest, err error) {
+	uow.Message.TransportError = Halted
+	uow.Message.Error = err
+	uow.cancel()
+	c.complete(uow)
+}
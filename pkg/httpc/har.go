@@ -0,0 +1,389 @@
+package httpc
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+
+	TransportError *TransportError `json:"_transportError,omitempty"`
+	Error          string          `json:"_error,omitempty"`
+	Waf            *WafDetection   `json:"_waf,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	Url         string         `json:"url"`
+	HttpVersion string         `json:"httpVersion"`
+	Cookies     []harCookie    `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HttpVersion string         `json:"httpVersion"`
+	Cookies     []harCookie    `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectUrl string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harCookie struct {
+	Name     string     `json:"name"`
+	Value    string     `json:"value"`
+	Path     string     `json:"path,omitempty"`
+	Domain   string     `json:"domain,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	HttpOnly bool       `json:"httpOnly,omitempty"`
+	Secure   bool       `json:"secure,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// ExportHar writes the messages as a HAR 1.2 file, each message is preceded
+// by the redirects that led to it and messages shared between chains are written once.
+func (log MessageLog) ExportHar(w io.Writer) error {
+	file := harFile{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "httpc", Version: "1.0"},
+		Entries: []harEntry{},
+	}}
+
+	written := map[*MessageDuplex]bool{}
+	for _, msg := range log {
+		chain := MessageLog{}
+		for tmp := msg; tmp != nil && !written[tmp]; tmp = tmp.Prev {
+			chain = append(MessageLog{tmp}, chain...)
+			written[tmp] = true
+		}
+
+		for _, hop := range chain {
+			if hop.Request == nil {
+				continue
+			}
+			entry, err := newHarEntry(hop)
+			if err != nil {
+				return err
+			}
+			file.Log.Entries = append(file.Log.Entries, entry)
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(file)
+}
+
+// ExportHar writes every message in the store as a HAR 1.2 file.
+func (s *MessageStore) ExportHar(w io.Writer) error {
+	return s.Messages().ExportHar(w)
+}
+
+func newHarEntry(msg *MessageDuplex) (harEntry, error) {
+	wait := float64(msg.Duration) / float64(time.Millisecond)
+
+	harReq, err := newHarRequest(msg)
+	if err != nil {
+		return harEntry{}, err
+	}
+
+	entry := harEntry{
+		StartedDateTime: msg.Timestamp,
+		Time:            wait,
+		Request:         harReq,
+		Response:        harResponse{Cookies: []harCookie{}, Headers: []harNameValue{}, HeadersSize: -1, BodySize: -1},
+		Timings:         harTimings{Send: 0, Wait: wait, Receive: 0},
+		Waf:             msg.Waf,
+	}
+
+	if msg.TransportError != NoError {
+		transportError := msg.TransportError
+		entry.TransportError = &transportError
+		if msg.Error != nil {
+			entry.Error = msg.Error.Error()
+		} else {
+			entry.Error = transportError.String()
+		}
+	}
+
+	if msg.Response != nil {
+		if entry.Response, err = newHarResponse(msg); err != nil {
+			return harEntry{}, err
+		}
+	}
+
+	return entry, nil
+}
+
+func newHarRequest(msg *MessageDuplex) (harRequest, error) {
+	req := msg.Request
+	harReq := harRequest{
+		Method:      req.Method,
+		Url:         req.URL.String(),
+		HttpVersion: req.Proto,
+		Cookies:     []harCookie{},
+		Headers:     harHeaders(req.Header),
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    0,
+	}
+
+	if req.Host != "" {
+		harReq.Headers = append([]harNameValue{{Name: "Host", Value: req.Host}}, harReq.Headers...)
+	}
+
+	for _, cookie := range req.Cookies() {
+		harReq.Cookies = append(harReq.Cookies, harCookie{Name: cookie.Name, Value: cookie.Value})
+	}
+
+	for name, values := range req.URL.Query() {
+		for _, value := range values {
+			harReq.QueryString = append(harReq.QueryString, harNameValue{Name: name, Value: value})
+		}
+	}
+
+	data, err := msg.requestBytes()
+	if err != nil {
+		return harRequest{}, err
+	}
+	if data != nil {
+		harReq.PostData = &harPostData{MimeType: req.Header.Get("Content-Type"), Text: string(data)}
+		harReq.BodySize = len(data)
+	}
+
+	return harReq, nil
+}
+
+func newHarResponse(msg *MessageDuplex) (harResponse, error) {
+	resp := msg.Response
+	harResp := harResponse{
+		Status:      resp.StatusCode,
+		StatusText:  strings.TrimSpace(strings.TrimPrefix(resp.Status, fmt.Sprint(resp.StatusCode))),
+		HttpVersion: resp.Proto,
+		Cookies:     []harCookie{},
+		Headers:     harHeaders(resp.Header),
+		RedirectUrl: resp.Header.Get("Location"),
+		HeadersSize: -1,
+	}
+
+	for _, cookie := range resp.Cookies() {
+		harCookie := harCookie{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Path:     cookie.Path,
+			Domain:   cookie.Domain,
+			HttpOnly: cookie.HttpOnly,
+			Secure:   cookie.Secure,
+		}
+		if !cookie.Expires.IsZero() {
+			harCookie.Expires = &cookie.Expires
+		}
+		harResp.Cookies = append(harResp.Cookies, harCookie)
+	}
+
+	body, err := msg.responseBytes()
+	if err != nil {
+		return harResponse{}, err
+	}
+	harResp.BodySize = len(body)
+	harResp.Content = harContent{Size: len(body), MimeType: resp.Header.Get("Content-Type")}
+	if utf8.Valid(body) {
+		harResp.Content.Text = string(body)
+	} else {
+		harResp.Content.Text = base64.StdEncoding.EncodeToString(body)
+		harResp.Content.Encoding = "base64"
+	}
+
+	return harResp, nil
+}
+
+func harHeaders(header http.Header) []harNameValue {
+	headers := []harNameValue{}
+	for name, values := range header {
+		for _, value := range values {
+			headers = append(headers, harNameValue{Name: name, Value: value})
+		}
+	}
+
+	return headers
+}
+
+// ReadHar loads the entries of a HAR file as messages, entries that were reached
+// through a redirect of the preceding entry are linked to it through Prev.
+func ReadHar(r io.Reader) (MessageLog, error) {
+	var file harFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to decode har file: %w", err)
+	}
+
+	log := MessageLog{}
+	var prev *MessageDuplex
+	for i, entry := range file.Log.Entries {
+		msg, err := entry.decode()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode har entry %d: %w", i, err)
+		}
+
+		if prev != nil && prev.Response != nil && prev.Response.StatusCode >= 300 && prev.Response.StatusCode <= 399 &&
+			ToAbsolute(prev.Request.URL.String(), prev.Response.Header.Get("Location")) == msg.Request.URL.String() {
+			msg.Prev = prev
+			log[len(log)-1] = msg
+		} else {
+			log = append(log, msg)
+		}
+		prev = msg
+	}
+
+	return log, nil
+}
+
+func (entry harEntry) decode() (*MessageDuplex, error) {
+	var body io.Reader
+	if entry.Request.PostData != nil {
+		body = strings.NewReader(entry.Request.PostData.Text)
+	}
+
+	req, err := http.NewRequest(entry.Request.Method, entry.Request.Url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, header := range entry.Request.Headers {
+		switch {
+		case strings.HasPrefix(header.Name, ":"), strings.EqualFold(header.Name, "Content-Length"):
+		case strings.EqualFold(header.Name, "Host"):
+			req.Host = header.Value
+		default:
+			req.Header.Add(header.Name, header.Value)
+		}
+	}
+
+	msg := &MessageDuplex{
+		Request:   req,
+		Timestamp: entry.StartedDateTime,
+		Duration:  time.Duration(entry.Timings.Wait * float64(time.Millisecond)),
+		Waf:       entry.Waf,
+	}
+	if entry.Request.PostData != nil {
+		msg.setRequestBody(NewBody([]byte(entry.Request.PostData.Text)))
+	}
+
+	if entry.TransportError != nil {
+		msg.TransportError = *entry.TransportError
+	}
+	if entry.Error != "" {
+		msg.Error = fmt.Errorf("%s", entry.Error)
+	}
+
+	if entry.Response.Status == 0 {
+		if msg.TransportError == NoError {
+			msg.TransportError = UnknownError
+		}
+		return msg, nil
+	}
+
+	content := []byte(entry.Response.Content.Text)
+	if entry.Response.Content.Encoding == "base64" {
+		content, err = base64.StdEncoding.DecodeString(entry.Response.Content.Text)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp := &http.Response{
+		Status:        strings.TrimSpace(fmt.Sprintf("%d %s", entry.Response.Status, entry.Response.StatusText)),
+		StatusCode:    entry.Response.Status,
+		Proto:         entry.Response.HttpVersion,
+		Header:        http.Header{},
+		ContentLength: int64(len(content)),
+		Request:       req,
+	}
+	resp.ProtoMajor, resp.ProtoMinor, _ = http.ParseHTTPVersion(resp.Proto)
+
+	for _, header := range entry.Response.Headers {
+		if !strings.HasPrefix(header.Name, ":") {
+			resp.Header.Add(header.Name, header.Value)
+		}
+	}
+	msg.Response = resp
+	msg.setResponseBody(NewBody(content))
+
+	return msg, nil
+}
+
+// ReplayHar sends the requests of a HAR file, entries that were reached through
+// a redirect are not sent since the client follows redirects itself.
+func (c *HttpClient) ReplayHar(r io.Reader, opts ClientOptions) ([]*Future, error) {
+	log, err := ReadHar(r)
+	if err != nil {
+		return nil, err
+	}
+
+	futures := []*Future{}
+	for _, msg := range log {
+		first := msg
+		for first.Prev != nil {
+			first = first.Prev
+		}
+		futures = append(futures, c.SendWithOptions(first.Request, opts))
+	}
+
+	return futures, nil
+}
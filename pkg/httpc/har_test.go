@@ -0,0 +1,87 @@
+package httpc_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/aristosMiliaressis/httpc/pkg/httpc"
+	"github.com/aristosMiliaressis/httpc/pkg/httpctest"
+)
+
+func TestHarRoundTrip(t *testing.T) {
+	srv := httpctest.NewServer().Script(
+		httpctest.Rule{Path: "/old", Behavior: httpctest.Redirect("/new", http.StatusMovedPermanently)},
+		httpctest.Rule{Path: "/new", Behavior: httpctest.Respond(200, http.Header{"Content-Type": {"text/plain"}}, "moved here")},
+		httpctest.Rule{Path: "/binary", Behavior: httpctest.Respond(200, nil, "\x00\xff\xfe")},
+	)
+	defer srv.Close()
+
+	c := httpctest.NewClient(t, nil)
+	httpctest.Send(t, c, "POST", srv.URL+"/submit", "a=1&b=2")
+	httpctest.Send(t, c, "GET", srv.URL+"/old", "")
+	httpctest.Send(t, c, "GET", srv.URL+"/binary", "")
+
+	var buf bytes.Buffer
+	if err := c.MessageLog.ExportHar(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	log, err := httpc.ReadHar(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log) != 3 {
+		t.Fatalf("expected 3 messages with the redirect folded into its target, got %d", len(log))
+	}
+
+	post := log[0]
+	if post.Request.Method != "POST" || post.RequestBody == nil {
+		t.Fatalf("expected the POST request with its body, got %s", post.Request.Method)
+	}
+	if body := post.RequestBody.String(); body != "a=1&b=2" {
+		t.Fatalf("expected the request body to survive the round trip, got %q", body)
+	}
+
+	redirected := log[1]
+	if redirected.Request.URL.String() != srv.URL+"/new" || redirected.ResponseBody.String() != "moved here" {
+		t.Fatalf("expected the redirect target with its body, got %s %q", redirected.Request.URL, redirected.ResponseBody.String())
+	}
+	if redirected.RedirectDepth() != 1 || redirected.Prev.Response.StatusCode != http.StatusMovedPermanently {
+		t.Fatalf("expected the redirect to be linked through Prev, depth %d", redirected.RedirectDepth())
+	}
+
+	if binary := log[2]; binary.ResponseBody.String() != "\x00\xff\xfe" {
+		t.Fatalf("expected binary bodies to survive the round trip, got %q", binary.ResponseBody.String())
+	}
+}
+
+func TestReplayHar(t *testing.T) {
+	srv := httpctest.NewServer().Script(httpctest.Rule{Path: "/old", Behavior: httpctest.Redirect("/new", http.StatusFound)})
+	defer srv.Close()
+
+	recorder := httpctest.NewClient(t, nil)
+	httpctest.Send(t, recorder, "GET", srv.URL+"/old", "")
+	httpctest.Send(t, recorder, "GET", srv.URL+"/other", "")
+
+	var buf bytes.Buffer
+	if err := recorder.MessageLog.ExportHar(&buf); err != nil {
+		t.Fatal(err)
+	}
+	srv.Reset()
+	srv.Script(httpctest.Rule{Path: "/old", Behavior: httpctest.Redirect("/new", http.StatusFound)})
+
+	c := httpctest.NewClient(t, nil)
+	futures, err := c.ReplayHar(&buf, c.Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, future := range futures {
+		future.Wait(context.Background())
+	}
+
+	// the redirect target is reached by following the redirect rather than being sent again
+	httpctest.AssertServerRequests(t, srv, 3)
+	httpctest.AssertRedirects(t, c, srv.URL+"/new", 1)
+}
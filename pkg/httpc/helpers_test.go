@@ -0,0 +1,83 @@
+package httpc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// newTestMessage creates a POST message to http://a.test/i tagged i, with "request i" and "response i" bodies.
+func newTestMessage(t *testing.T, i int) *MessageDuplex {
+	req, err := http.NewRequest("POST", fmt.Sprintf("http://a.test/%d", i), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &MessageDuplex{
+		Request: req,
+		Response: &http.Response{
+			Status:        "200 OK",
+			StatusCode:    200,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": {"text/plain"}},
+			ContentLength: -1,
+		},
+		Tags: []string{fmt.Sprint(i)},
+	}
+	msg.setRequestBody(NewBody([]byte(fmt.Sprintf("request %d", i))))
+	msg.setResponseBody(NewBody([]byte(fmt.Sprintf("response %d", i))))
+
+	return msg
+}
+
+// newTestPool creates a running thread pool that is stopped when the test ends.
+func newTestPool(t *testing.T, opts PerformanceOptions, callback func(uow PendingRequest)) *ThreadPool {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	tp := NewThreadPool(callback, ctx, opts)
+	go tp.Run()
+
+	return tp
+}
+
+// newTestRequest creates a pending GET request tagged tag.
+func newTestRequest(t *testing.T, rawUrl string, priority Priority, tag string) PendingRequest {
+	req, err := http.NewRequest("GET", rawUrl, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return PendingRequest{
+		Message: &MessageDuplex{Request: req, Tags: []string{tag}},
+		Options: ClientOptions{RequestPriority: priority},
+		future:  newFuture(),
+	}
+}
+
+func waitPool(t *testing.T, tp *ThreadPool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := tp.Wait(ctx); err != nil {
+		t.Fatalf("pool did not drain: %s", err)
+	}
+}
+
+func waitFuture(t *testing.T, f *Future) *MessageDuplex {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	msg, err := f.Wait(ctx)
+	if err != nil {
+		t.Fatalf("future did not complete: %s", err)
+	}
+
+	return msg
+}
@@ -0,0 +1,200 @@
+package httpc
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/projectdiscovery/gologger"
+)
+
+// Canary is a request known to succeed against a host, it is used to verify
+// whether errors from that host are caused by an ip ban.
+type Canary struct {
+	Request *http.Request
+	// ExpectedStatus is the status the canary is known to get when not banned,
+	// if zero the canary is considered banned when it fails the same way as the request that triggered the verification
+	ExpectedStatus int
+}
+
+// SetCanary configures the request used to verify ip bans of a host,
+// without one a previously successful request to the host is replayed.
+func (c *HttpClient) SetCanary(host string, canary Canary) {
+	c.banMutex.Lock()
+	defer c.banMutex.Unlock()
+
+	c.canaries[host] = canary
+}
+
+// PauseHost stops sending queued requests to a host until ResumeHost is called.
+func (c *HttpClient) PauseHost(host string) {
+	c.ThreadPool.PauseHost(host)
+	c.Events.hostPaused(host)
+}
+
+func (c *HttpClient) ResumeHost(host string) {
+	c.ThreadPool.ResumeHost(host)
+	c.Events.hostResumed(host)
+}
+
+// verifyIpBan sends a canary request on behalf of the worker that got msg,
+// it returns true if the canary confirms the ban.
+func (c *HttpClient) verifyIpBan(msg *MessageDuplex) bool {
+	host := msg.Request.URL.Host
+	if !c.startBanCheck(host) {
+		return false
+	}
+	defer c.endBanCheck(host)
+
+	gologger.Warning().Msgf("Potential IP ban of %s detected, verifying..", host)
+
+	future := c.sendCanary(msg)
+	c.ThreadPool.awaitNested(host, future.Done())
+
+	select {
+	case <-c.context.Done():
+		return false
+	default:
+	}
+
+	banned := c.isBanned(msg, future.Message())
+	if banned {
+		gologger.Warning().Msgf("IP ban of %s detected.", host)
+	} else {
+		gologger.Warning().Msg("No IP ban, continuing..")
+	}
+	c.Events.ipBanVerified(msg, future.Message(), banned)
+
+	return banned
+}
+
+// coolDown pauses the host of a confirmed ban and verifies the ban again after every
+// cooldown period, the host is resumed with its error counts reset once the ban is lifted.
+func (c *HttpClient) coolDown(msg *MessageDuplex) {
+	host := msg.Request.URL.Host
+	if !c.startBanCheck(host) {
+		return
+	}
+
+	c.PauseHost(host)
+
+	go func() {
+		defer c.endBanCheck(host)
+
+		for {
+			select {
+			case <-c.context.Done():
+				return
+			case <-time.After(c.Options.ErrorHandling.BanCooldown):
+			}
+
+			future := c.sendCanary(msg)
+			select {
+			case <-c.context.Done():
+				return
+			case <-future.Done():
+			}
+
+			banned := c.isBanned(msg, future.Message())
+			c.Events.ipBanVerified(msg, future.Message(), banned)
+			if !banned {
+				break
+			}
+
+			gologger.Warning().Msgf("IP ban of %s still in place, cooling down for %s.", host, c.Options.ErrorHandling.BanCooldown)
+		}
+
+		gologger.Warning().Msgf("IP ban of %s lifted, resuming.", host)
+
+		c.errorMutex.Lock()
+		delete(c.hostErrorStats, host)
+		c.errorMutex.Unlock()
+
+		c.ResumeHost(host)
+	}()
+}
+
+// startBanCheck marks a host as being verified, it returns false if it already is.
+func (c *HttpClient) startBanCheck(host string) bool {
+	c.banMutex.Lock()
+	defer c.banMutex.Unlock()
+
+	if c.banChecks[host] {
+		return false
+	}
+	c.banChecks[host] = true
+
+	return true
+}
+
+func (c *HttpClient) endBanCheck(host string) {
+	c.banMutex.Lock()
+	defer c.banMutex.Unlock()
+
+	delete(c.banChecks, host)
+}
+
+// sendCanary queues the canary of msg's host ahead of every other request,
+// falling back to the last successful request to the host.
+func (c *HttpClient) sendCanary(msg *MessageDuplex) *Future {
+	var req *http.Request
+
+	c.banMutex.Lock()
+	canary, ok := c.canaries[msg.Request.URL.Host]
+	c.banMutex.Unlock()
+
+	if ok {
+		req = canary.Request.Clone(c.context)
+	} else {
+		req = c.baselineRequest(msg).Clone(c.context)
+	}
+
+	opts := c.Options
+	opts.RequestPriority = 1000
+	opts.Performance.ReplayRateLimitted = false
+	uow := c.newPendingRequest(c.context, req, opts)
+	uow.nested = true
+
+	return c.enqueue(uow)
+}
+
+// setBaseline remembers the request of a successful message, it is replayed to verify ip bans of its host.
+func (c *HttpClient) setBaseline(msg *MessageDuplex) {
+	c.banMutex.Lock()
+	defer c.banMutex.Unlock()
+
+	c.baselines[msg.Request.URL.Host] = msg.Request
+}
+
+// baselineRequest returns the last successful request to msg's host, or msg's own request if there is none.
+func (c *HttpClient) baselineRequest(msg *MessageDuplex) *http.Request {
+	c.banMutex.Lock()
+	defer c.banMutex.Unlock()
+
+	if req, ok := c.baselines[msg.Request.URL.Host]; ok {
+		return req
+	}
+
+	return msg.Request
+}
+
+// isBanned compares the canary's result against its expected status or,
+// if there isn't one, against the result of the request that triggered the verification.
+func (c *HttpClient) isBanned(msg *MessageDuplex, canaryMsg *MessageDuplex) bool {
+	c.banMutex.Lock()
+	canary, ok := c.canaries[msg.Request.URL.Host]
+	c.banMutex.Unlock()
+
+	if ok && canary.ExpectedStatus != 0 {
+		return canaryMsg.Response == nil || canaryMsg.Response.StatusCode != canary.ExpectedStatus || canaryMsg.Waf != nil
+	}
+
+	if msg.Waf != nil {
+		return canaryMsg.Response == nil || canaryMsg.Waf != nil
+	}
+
+	if msg.TransportError != NoError {
+		return canaryMsg.TransportError == msg.TransportError
+	}
+
+	return canaryMsg.Response == nil || canaryMsg.Response.Status == msg.Response.Status
+}
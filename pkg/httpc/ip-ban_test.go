@@ -0,0 +1,91 @@
+package httpc_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aristosMiliaressis/httpc/pkg/httpc"
+	"github.com/aristosMiliaressis/httpc/pkg/httpctest"
+)
+
+func TestIpBanVerification(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    []httpctest.Rule
+		cooldown time.Duration
+		// want lists the paths of the canaries and whether they confirmed the ban, followed by the host events
+		want       string
+		wantHalted bool
+	}{
+		{
+			name:  "errors without a ban",
+			rules: []httpctest.Rule{{Path: "/err", Behavior: httpctest.Status(http.StatusForbidden)}},
+			want:  "canary / false",
+		},
+		{
+			name:       "ban halts the host",
+			rules:      []httpctest.Rule{{After: 1, Behavior: httpctest.Status(http.StatusForbidden)}},
+			want:       "canary / true",
+			wantHalted: true,
+		},
+		{
+			name:     "ban cools down until it is lifted",
+			rules:    []httpctest.Rule{{After: 1, Times: 4, Behavior: httpctest.Status(http.StatusForbidden)}},
+			cooldown: 50 * time.Millisecond,
+			want:     "canary / true, paused, canary / true, canary / false, resumed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httpctest.NewServer().Script(tt.rules...)
+			defer srv.Close()
+
+			c := httpctest.NewClient(t, func(opts *httpc.ClientOptions) {
+				opts.ErrorHandling.ConsecutiveThreshold = 1
+				opts.ErrorHandling.VerifyIPBanIfExheeded = true
+				opts.ErrorHandling.BanCooldown = tt.cooldown
+				// the canary doesn't depend on the successful message still being in the log
+				opts.MessageLog.MaxMessages = 1
+			})
+			log := &eventLog{}
+			resumed := make(chan struct{})
+			c.Events = httpc.EventHooks{
+				OnIpBanVerified: func(msg *httpc.MessageDuplex, canary *httpc.MessageDuplex, banned bool) {
+					log.add("canary %s %v", canary.Request.URL.Path, banned)
+				},
+				OnHostPaused: func(host string) { log.add("paused") },
+				OnHostResumed: func(host string) {
+					log.add("resumed")
+					close(resumed)
+				},
+			}
+
+			// the successful request is replayed as the canary
+			httpctest.Send(t, c, "GET", srv.URL+"/", "")
+			httpctest.Send(t, c, "GET", srv.URL+"/err", "")
+			httpctest.Send(t, c, "GET", srv.URL+"/err", "")
+
+			if tt.cooldown > 0 {
+				select {
+				case <-resumed:
+				case <-time.After(5 * time.Second):
+					t.Fatalf("expected the host to be resumed, got %q", log.String())
+				}
+				if msg := httpctest.Send(t, c, "GET", srv.URL+"/", ""); msg.Response == nil || msg.Response.StatusCode != http.StatusOK {
+					t.Fatal("expected requests to succeed once the ban is lifted")
+				}
+			}
+
+			if got := log.String(); got != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+			if tt.wantHalted {
+				httpctest.AssertHalted(t, c, srv)
+			} else {
+				httpctest.AssertNotHalted(t, c, srv)
+			}
+		})
+	}
+}
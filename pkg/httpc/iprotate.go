@@ -4,22 +4,22 @@ import (
 	"net/url"
 
 	"github.com/aristosMiliaressis/go-ip-rotate/pkg/iprotate"
-	"github.com/projectdiscovery/gologger"
 )
 
-func (c *HttpClient) enableIpRotate(url *url.URL) {
-	var err error
-
+func (c *HttpClient) enableIpRotate(url *url.URL) error {
 	baseUrl := GetBaseUrl(url)
 
 	c.apiGatewayMutex.Lock()
 	defer c.apiGatewayMutex.Unlock()
 	if c.apiGateways[baseUrl.String()] != nil {
-		return
+		return nil
 	}
 
-	c.apiGateways[baseUrl.String()], err = iprotate.CreateApi(c.Options.ErrorHandling.AwsProfile, baseUrl)
+	gateway, err := iprotate.CreateApi(c.Options.ErrorHandling.AwsProfile, baseUrl)
 	if err != nil {
-		gologger.Fatal().Msgf("Error while creating api gateway for ip rotation")
+		return &IpRotateError{BaseUrl: baseUrl.String(), Err: err}
 	}
+	c.apiGateways[baseUrl.String()] = gateway
+
+	return nil
 }
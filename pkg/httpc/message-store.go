@@ -0,0 +1,338 @@
+package httpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/projectdiscovery/gologger"
+)
+
+// MessageStore is a concurrency safe log of messages that keeps at most MaxMessages
+// of the most recent messages in memory, older ones are either dropped or spilled to disk.
+type MessageStore struct {
+	mutex sync.RWMutex
+	opts  MessageLogOptions
+
+	messages MessageLog
+	evicted  int
+	// bodies stores the bodies of the messages, its files are removed with the messages or the spill file
+	bodies *bodyStore
+
+	spillFile  *os.File
+	spillSize  int64
+	spillCount int
+}
+
+func NewMessageStore(opts MessageLogOptions) *MessageStore {
+	return &MessageStore{opts: opts, bodies: &bodyStore{opts: opts}}
+}
+
+// Append adds a message to the log, evicting the oldest in-memory message if the log is full.
+func (s *MessageStore) Append(msg *MessageDuplex) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.messages = append(s.messages, msg)
+	for prev := msg.Prev; prev != nil; prev = prev.Prev {
+		retainBodies(prev)
+	}
+	if s.opts.MaxMessages <= 0 || len(s.messages) <= s.opts.MaxMessages {
+		return
+	}
+
+	oldest := s.messages[0]
+	s.messages[0] = nil
+	s.messages = s.messages[1:]
+	defer releaseBodies(oldest)
+
+	if s.opts.SpillDir == "" {
+		s.evict()
+		return
+	}
+
+	if err := s.spill(oldest); err != nil {
+		gologger.Debug().Msgf("failed to spill message to disk: %s", err)
+		s.evict()
+	}
+}
+
+// retainBodies marks the bodies of a message as used by one more message in the log.
+func retainBodies(msg *MessageDuplex) {
+	msg.RequestBody.retain()
+	msg.ResponseBody.retain()
+}
+
+// releaseBodies releases the bodies of a message leaving the log and of the messages it links to.
+func releaseBodies(msg *MessageDuplex) {
+	for ; msg != nil; msg = msg.Prev {
+		msg.RequestBody.release()
+		msg.ResponseBody.release()
+	}
+}
+
+// evict counts a dropped message, must be called with the write lock held.
+func (s *MessageStore) evict() {
+	if s.evicted == 0 {
+		gologger.Warning().Msgf("message log is full, dropping messages beyond the most recent %d, set MessageLog.SpillDir to keep them", s.opts.MaxMessages)
+	}
+	s.evicted++
+}
+
+// spill appends a message to the spill file, must be called with the write lock held.
+func (s *MessageStore) spill(msg *MessageDuplex) error {
+	if s.spillFile == nil {
+		file, err := os.CreateTemp(s.opts.SpillDir, "httpc-messages-*.jsonl")
+		if err != nil {
+			return err
+		}
+		s.spillFile = file
+	}
+
+	stored, err := encodeMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	n, err := s.spillFile.WriteAt(line, s.spillSize)
+	s.spillSize += int64(n)
+	if err != nil {
+		return err
+	}
+	s.spillCount++
+
+	return nil
+}
+
+// Len returns the number of messages in memory and on disk, dropped messages are not counted.
+func (s *MessageStore) Len() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return len(s.messages) + s.spillCount
+}
+
+// Evicted returns the number of messages dropped because the log was full.
+func (s *MessageStore) Evicted() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.evicted
+}
+
+// Recent returns up to n of the most recent messages.
+func (s *MessageStore) Recent(n int) MessageLog {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	idx := max(len(s.messages)-n, 0)
+
+	return append(MessageLog{}, s.messages[idx:]...)
+}
+
+// Messages returns every message in the log, oldest first, loading spilled messages from disk.
+func (s *MessageStore) Messages() MessageLog {
+	messages := MessageLog{}
+	s.each(func(msg *MessageDuplex) bool {
+		messages = append(messages, msg)
+		return true
+	})
+
+	return messages
+}
+
+// each calls fn for every message, oldest first, until it returns false.
+// fn is called without holding the lock so it may use the store.
+func (s *MessageStore) each(fn func(msg *MessageDuplex) bool) {
+	s.mutex.RLock()
+	spillFile, spillSize := s.spillFile, s.spillSize
+	messages := append(MessageLog{}, s.messages...)
+	s.mutex.RUnlock()
+
+	if spillFile != nil {
+		scanner := bufio.NewScanner(io.NewSectionReader(spillFile, 0, spillSize))
+		scanner.Buffer(nil, 64*1024*1024)
+		for scanner.Scan() {
+			var stored storedMessage
+			if err := json.Unmarshal(scanner.Bytes(), &stored); err != nil {
+				gologger.Debug().Msgf("failed to read spilled message: %s", err)
+				continue
+			}
+			if !fn(stored.decode()) {
+				return
+			}
+		}
+	}
+
+	for _, msg := range messages {
+		if !fn(msg) {
+			return
+		}
+	}
+}
+
+func (s *MessageStore) Find(where func(msg *MessageDuplex) bool) *MessageDuplex {
+	var found *MessageDuplex
+	s.each(func(msg *MessageDuplex) bool {
+		if where(msg) {
+			found = msg
+			return false
+		}
+		return true
+	})
+
+	return found
+}
+
+func (s *MessageStore) Search(where func(msg *MessageDuplex) bool) MessageLog {
+	found := MessageLog{}
+	s.each(func(msg *MessageDuplex) bool {
+		found = append(found, MessageLog{msg}.Search(where)...)
+		return true
+	})
+
+	return found
+}
+
+func (s *MessageStore) Select(filter func(msg *MessageDuplex) string) []string {
+	selected := []string{}
+	s.each(func(msg *MessageDuplex) bool {
+		selected = append(selected, MessageLog{msg}.Select(filter)...)
+		return true
+	})
+
+	return selected
+}
+
+func (s *MessageStore) SelectInt64(filter func(msg *MessageDuplex) int64) []int64 {
+	selected := []int64{}
+	s.each(func(msg *MessageDuplex) bool {
+		selected = append(selected, MessageLog{msg}.SelectInt64(filter)...)
+		return true
+	})
+
+	return selected
+}
+
+// Close removes the spill file and the files of stored bodies,
+// spilled messages and bodies kept in files are no longer available afterwards.
+func (s *MessageStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := s.bodies.close()
+	if s.spillFile == nil {
+		return err
+	}
+
+	err = errors.Join(err, s.spillFile.Close(), os.Remove(s.spillFile.Name()))
+	s.spillFile = nil
+	s.spillSize = 0
+	s.spillCount = 0
+
+	return err
+}
+
+// storedMessage is the serialized form of a MessageDuplex,
+// requests and responses are kept as raw HTTP/1 messages.
+type storedMessage struct {
+	TransportError TransportError
+	Error          string `json:",omitempty"`
+	Duration       time.Duration
+	Timestamp      time.Time
+	Tags           []string       `json:",omitempty"`
+	Url            string         `json:",omitempty"`
+	Request        []byte         `json:",omitempty"`
+	Response       []byte         `json:",omitempty"`
+	Waf            *WafDetection  `json:",omitempty"`
+	Prev           *storedMessage `json:",omitempty"`
+}
+
+func encodeMessage(msg *MessageDuplex) (*storedMessage, error) {
+	stored := &storedMessage{
+		TransportError: msg.TransportError,
+		Duration:       msg.Duration,
+		Timestamp:      msg.Timestamp,
+		Tags:           msg.Tags,
+		Waf:            msg.Waf,
+	}
+
+	if msg.Error != nil {
+		stored.Error = msg.Error.Error()
+	}
+
+	var err error
+	if msg.Request != nil {
+		stored.Url = msg.Request.URL.String()
+		if stored.Request, err = dumpRequest(msg.Request); err != nil {
+			return nil, err
+		}
+	}
+
+	if msg.Response != nil {
+		if stored.Response, err = dumpResponse(msg); err != nil {
+			return nil, err
+		}
+	}
+
+	if msg.Prev != nil {
+		if stored.Prev, err = encodeMessage(msg.Prev); err != nil {
+			return nil, err
+		}
+	}
+
+	return stored, nil
+}
+
+func (stored *storedMessage) decode() *MessageDuplex {
+	msg := &MessageDuplex{
+		TransportError: stored.TransportError,
+		Duration:       stored.Duration,
+		Timestamp:      stored.Timestamp,
+		Tags:           stored.Tags,
+		Waf:            stored.Waf,
+	}
+
+	if stored.Error != "" {
+		msg.Error = errors.New(stored.Error)
+	}
+
+	if stored.Request != nil {
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(stored.Request)))
+		if err == nil {
+			req.RequestURI = ""
+			if u, err := url.Parse(stored.Url); err == nil {
+				req.URL = u
+			}
+			msg.Request = req
+			msg.setRequestBody(readBody(req.Body))
+		}
+	}
+
+	if stored.Response != nil {
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(stored.Response)), msg.Request)
+		if err == nil {
+			msg.Response = resp
+			msg.setResponseBody(readBody(resp.Body))
+		}
+	}
+
+	if stored.Prev != nil {
+		msg.Prev = stored.Prev.decode()
+	}
+
+	return msg
+}
@@ -0,0 +1,184 @@
+package httpc
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func messagePaths(log MessageLog) string {
+	paths := []string{}
+	for _, msg := range log {
+		paths = append(paths, msg.Request.URL.Path)
+	}
+
+	return strings.Join(paths, ",")
+}
+
+func TestMessageStoreUnbounded(t *testing.T) {
+	s := NewMessageStore(MessageLogOptions{})
+	for i := 0; i < 5; i++ {
+		s.Append(newTestMessage(t, i))
+	}
+
+	if s.Len() != 5 || s.Evicted() != 0 {
+		t.Fatalf("expected 5 messages and none evicted, got %d and %d", s.Len(), s.Evicted())
+	}
+	if got := messagePaths(s.Recent(2)); got != "/3,/4" {
+		t.Fatalf("expected the 2 most recent messages, got %s", got)
+	}
+}
+
+func TestMessageStoreEviction(t *testing.T) {
+	s := NewMessageStore(MessageLogOptions{MaxMessages: 3})
+	for i := 0; i < 5; i++ {
+		s.Append(newTestMessage(t, i))
+	}
+
+	if s.Len() != 3 || s.Evicted() != 2 {
+		t.Fatalf("expected 3 messages and 2 evicted, got %d and %d", s.Len(), s.Evicted())
+	}
+	if got := messagePaths(s.Messages()); got != "/2,/3,/4" {
+		t.Fatalf("expected the oldest messages to be dropped, got %s", got)
+	}
+}
+
+func TestMessageStoreSpill(t *testing.T) {
+	dir := t.TempDir()
+	s := NewMessageStore(MessageLogOptions{MaxMessages: 2, SpillDir: dir})
+	for i := 0; i < 5; i++ {
+		s.Append(newTestMessage(t, i))
+	}
+
+	if s.Len() != 5 || s.Evicted() != 0 {
+		t.Fatalf("expected 5 messages and none evicted, got %d and %d", s.Len(), s.Evicted())
+	}
+	if got := messagePaths(s.Messages()); got != "/0,/1,/2,/3,/4" {
+		t.Fatalf("expected spilled messages first, got %s", got)
+	}
+	if got := messagePaths(s.Recent(10)); got != "/3,/4" {
+		t.Fatalf("expected Recent to only return in-memory messages, got %s", got)
+	}
+
+	spilled := s.Find(func(msg *MessageDuplex) bool { return msg.Request.URL.Path == "/1" })
+	if spilled == nil {
+		t.Fatal("expected to find a spilled message")
+	}
+	if spilled.Response.StatusCode != 200 || spilled.ResponseBody.String() != "response 1" {
+		t.Fatalf("expected the spilled response to be restored, got %d %q", spilled.Response.StatusCode, spilled.ResponseBody.String())
+	}
+	if spilled.RequestBody.String() != "request 1" || spilled.Request.Method != "POST" {
+		t.Fatalf("expected the spilled request to be restored, got %s %q", spilled.Request.Method, spilled.RequestBody.String())
+	}
+	if len(spilled.Tags) != 1 || spilled.Tags[0] != "1" {
+		t.Fatalf("expected the tags to be restored, got %v", spilled.Tags)
+	}
+
+	if got := len(s.Search(func(msg *MessageDuplex) bool { return msg.Response.StatusCode == 200 })); got != 5 {
+		t.Fatalf("expected Search to include spilled messages, got %d", got)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("expected the spill file to be removed, %d files left", len(entries))
+	}
+	if s.Len() != 2 {
+		t.Fatalf("expected only in-memory messages after Close, got %d", s.Len())
+	}
+}
+
+func TestMessageStoreCloseRemovesBodies(t *testing.T) {
+	dir := t.TempDir()
+	s := NewMessageStore(MessageLogOptions{SpillDir: dir, MaxBodyMemory: 4})
+
+	small, err := s.bodies.store(strings.NewReader("abc"))
+	if err != nil || small.InFile() {
+		t.Fatalf("expected a small body to stay in memory, got %v", err)
+	}
+
+	large, err := s.bodies.store(strings.NewReader("large body"))
+	if err != nil || !large.InFile() || large.Len() != 10 {
+		t.Fatalf("expected a large body to be kept in a file, got %v", err)
+	}
+	if large.String() != "large body" {
+		t.Fatalf("expected the body to be read back from its file, got %q", large.String())
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("expected the body files to be removed, %d files left", len(entries))
+	}
+	if _, err := large.Bytes(); err == nil {
+		t.Fatal("expected reading a removed body to fail")
+	}
+}
+
+func TestMessageStoreReleasesBodies(t *testing.T) {
+	tests := []struct {
+		name  string
+		spill bool
+	}{
+		{"dropped", false},
+		{"spilled", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := MessageLogOptions{MaxMessages: 1, MaxBodyMemory: 4, SpillDir: t.TempDir()}
+			s := NewMessageStore(opts)
+			if !tt.spill {
+				// bodies are still kept in files in the temporary directory
+				s.bodies.opts.SpillDir = opts.SpillDir
+				s.opts.SpillDir = ""
+			}
+			defer s.Close()
+
+			// the first message's request body is shared with the second one, e.g. by a retry
+			store := func(body string) *Body {
+				stored, err := s.bodies.store(strings.NewReader(body))
+				if err != nil || !stored.InFile() {
+					t.Fatalf("expected the body to be kept in a file, got %v", err)
+				}
+				return stored
+			}
+			first, second, third := newTestMessage(t, 0), newTestMessage(t, 1), newTestMessage(t, 2)
+			shared := store("shared request")
+			first.setRequestBody(shared)
+			first.setResponseBody(store("first response"))
+			second.Request.Body, second.Request.GetBody = shared.Reader(), shared.getBody
+			if err := second.storeRequestBody(s.bodies); err != nil {
+				t.Fatal(err)
+			}
+			second.setResponseBody(store("second response"))
+			third.Prev = second
+
+			s.Append(first)
+			s.Append(second)
+			if first.ResponseBody.String() != "" || second.RequestBody.String() != "shared request" {
+				t.Fatal("expected the evicted message's own body files to be removed and the shared one to be kept")
+			}
+
+			// the third message links to the second one, so its bodies are kept after it is evicted
+			s.Append(third)
+			if second.RequestBody.String() != "shared request" || second.ResponseBody.String() != "second response" {
+				t.Fatal("expected the bodies of a linked message to be kept")
+			}
+
+			s.Append(newTestMessage(t, 3))
+			if second.RequestBody.String() != "" || second.ResponseBody.String() != "" {
+				t.Fatal("expected the body files to be removed with the last message using them")
+			}
+
+			if tt.spill {
+				spilled := s.Find(func(msg *MessageDuplex) bool { return msg.Request.URL.Path == "/1" })
+				if spilled == nil || spilled.RequestBody.String() != "shared request" || spilled.ResponseBody.String() != "second response" {
+					t.Fatal("expected the spilled message to keep its bodies")
+				}
+			}
+		})
+	}
+}
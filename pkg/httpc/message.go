@@ -7,11 +7,22 @@ import (
 
 type MessageDuplex struct {
 	TransportError TransportError
-	Duration       time.Duration
+	// Error is the cause of the transport error, a *TransportFailure for failed requests
+	// or the error the client or host was halted with for halted ones
+	Error    error `json:",omitempty"`
+	Duration time.Duration
+	// Timestamp is when the request was sent
+	Timestamp time.Time
+	Tags      []string `json:",omitempty"`
 
 	Request  *http.Request
 	Response *http.Response
-	Resolved chan bool
+	// RequestBody and ResponseBody are the stored bodies of the request and the response,
+	// unlike Request.Body and Response.Body they can be read any number of times
+	RequestBody  *Body `json:"-"`
+	ResponseBody *Body `json:"-"`
+	// Waf is set if the response was identified as a WAF block, challenge or captcha page
+	Waf *WafDetection `json:",omitempty"`
 
 	// Redirect Chain
 	Prev *MessageDuplex
@@ -19,15 +30,11 @@ type MessageDuplex struct {
 
 func (e MessageDuplex) RedirectDepth() int {
 	depth := 0
-	tmp := *e.Prev
-	for {
-		if tmp.Prev == nil {
-			return depth
-		}
-
-		tmp = *tmp.Prev
+	for tmp := e.Prev; tmp != nil; tmp = tmp.Prev {
 		depth++
 	}
+
+	return depth
 }
 
 func (e MessageDuplex) IsRedirectLoop() bool {
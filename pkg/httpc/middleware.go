@@ -0,0 +1,299 @@
+package httpc
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/aristosMiliaressis/httpc/internal/util"
+	"github.com/corpix/uarand"
+)
+
+// RequestMiddleware mutates a message's request before it is queued,
+// returning an error fails the request without sending it.
+type RequestMiddleware interface {
+	ProcessRequest(c *HttpClient, msg *MessageDuplex, opts ClientOptions) error
+}
+
+// ResponseMiddleware processes a received response, returning a FollowUp stops the
+// chain and sends the follow-up request in place of completing the current one,
+// returning an error stops the chain and completes the request as is.
+type ResponseMiddleware interface {
+	ProcessResponse(c *HttpClient, msg *MessageDuplex, opts ClientOptions) (*FollowUp, error)
+}
+
+type RequestMiddlewareFunc func(c *HttpClient, msg *MessageDuplex, opts ClientOptions) error
+
+func (f RequestMiddlewareFunc) ProcessRequest(c *HttpClient, msg *MessageDuplex, opts ClientOptions) error {
+	return f(c, msg, opts)
+}
+
+type ResponseMiddlewareFunc func(c *HttpClient, msg *MessageDuplex, opts ClientOptions) (*FollowUp, error)
+
+func (f ResponseMiddlewareFunc) ProcessResponse(c *HttpClient, msg *MessageDuplex, opts ClientOptions) (*FollowUp, error) {
+	return f(c, msg, opts)
+}
+
+// FollowUp is a request sent in continuation of another one,
+// the original request's future completes with the follow-up's message.
+type FollowUp struct {
+	Request *http.Request
+	Options ClientOptions
+	// Redirect links the follow-up's message to the current one through Prev
+	Redirect bool
+}
+
+// DefaultRequestMiddlewares returns the built-in request middlewares in the order they are applied.
+func DefaultRequestMiddlewares() []RequestMiddleware {
+	return []RequestMiddleware{
+		IpRotateMiddleware{},
+		BrowserSimulationMiddleware{},
+		RandomUserAgentMiddleware{},
+		DefaultHeadersMiddleware{},
+		CookieJarMiddleware{},
+		CacheBustingMiddleware{},
+	}
+}
+
+// DefaultResponseMiddlewares returns the built-in response middlewares in the order they are applied.
+func DefaultResponseMiddlewares() []ResponseMiddleware {
+	return []ResponseMiddleware{
+		CookieJarMiddleware{},
+		DecompressionMiddleware{},
+		WafDetectionMiddleware{},
+		ErrorHandlingMiddleware{},
+		RedirectMiddleware{},
+		ReplayRateLimitedMiddleware{},
+	}
+}
+
+// UseRequestMiddleware appends middlewares to the end of the request chain.
+func (c *HttpClient) UseRequestMiddleware(middlewares ...RequestMiddleware) {
+	c.RequestMiddlewares = append(c.RequestMiddlewares, middlewares...)
+}
+
+// UseResponseMiddleware appends middlewares to the end of the response chain.
+func (c *HttpClient) UseResponseMiddleware(middlewares ...ResponseMiddleware) {
+	c.ResponseMiddlewares = append(c.ResponseMiddlewares, middlewares...)
+}
+
+// IpRotateMiddleware rewrites request urls to go through the api gateway created for their origin.
+type IpRotateMiddleware struct{}
+
+func (IpRotateMiddleware) ProcessRequest(c *HttpClient, msg *MessageDuplex, opts ClientOptions) error {
+	if opts.Connection.EnableIPRotate {
+		if err := c.enableIpRotate(msg.Request.URL); err != nil {
+			c.haltHost(msg.Request.URL.Host, err)
+			return err
+		}
+	}
+
+	c.apiGatewayMutex.Lock()
+	defer c.apiGatewayMutex.Unlock()
+
+	baseUrl := GetBaseUrl(msg.Request.URL).String()
+	if gateway, ok := c.apiGateways[baseUrl]; ok {
+		gatewayUrl, err := url.Parse(strings.Replace(msg.Request.URL.String(), baseUrl, gateway.ProxyUrl, 1))
+		if err != nil {
+			return fmt.Errorf("failed to update url to ip-rotate url: %w", err)
+		}
+		msg.Request.URL = gatewayUrl
+	}
+
+	return nil
+}
+
+type BrowserSimulationMiddleware struct{}
+
+func (BrowserSimulationMiddleware) ProcessRequest(c *HttpClient, msg *MessageDuplex, opts ClientOptions) error {
+	if opts.SimulateBrowserRequests {
+		util.SimulateBrowserRequest(msg.Request)
+	}
+
+	return nil
+}
+
+type RandomUserAgentMiddleware struct{}
+
+func (RandomUserAgentMiddleware) ProcessRequest(c *HttpClient, msg *MessageDuplex, opts ClientOptions) error {
+	if opts.RandomizeUserAgent {
+		msg.Request.Header.Set("User-Agent", uarand.GetRandom())
+	}
+
+	return nil
+}
+
+// DefaultHeadersMiddleware sets the configured default headers,
+// keeps a single value per header and drops headers not allowed in HTTP/2.
+type DefaultHeadersMiddleware struct{}
+
+func (DefaultHeadersMiddleware) ProcessRequest(c *HttpClient, msg *MessageDuplex, opts ClientOptions) error {
+	for k, v := range opts.DefaultHeaders {
+		msg.Request.Header.Set(k, v)
+	}
+
+	for k, v := range msg.Request.Header {
+		msg.Request.Header.Set(k, v[0])
+	}
+
+	if msg.Request.ProtoMajor == 2 {
+		msg.Request.Header.Del("Connection")
+		msg.Request.Header.Del("Upgrade")
+		msg.Request.Header.Del("Transfer-Encoding")
+	}
+
+	return nil
+}
+
+// CookieJarMiddleware adds the cookie jar's cookies to requests and,
+// if MaintainCookieJar is set, stores cookies set by responses.
+type CookieJarMiddleware struct{}
+
+func (CookieJarMiddleware) ProcessRequest(c *HttpClient, msg *MessageDuplex, opts ClientOptions) error {
+	for k, v := range c.GetCookieJar() {
+		if ContainsCookie(msg.Request, k) || util.Contains(opts.ExcludeCookies, k) {
+			continue
+		}
+		msg.Request.AddCookie(&http.Cookie{Name: k, Value: v})
+	}
+
+	return nil
+}
+
+func (CookieJarMiddleware) ProcessResponse(c *HttpClient, msg *MessageDuplex, opts ClientOptions) (*FollowUp, error) {
+	if opts.MaintainCookieJar && msg.Response.Cookies() != nil {
+		for _, cookie := range msg.Response.Cookies() {
+			c.AddCookie(cookie.Name, cookie.Value)
+		}
+	}
+
+	return nil, nil
+}
+
+type CacheBustingMiddleware struct{}
+
+func (CacheBustingMiddleware) ProcessRequest(c *HttpClient, msg *MessageDuplex, opts ClientOptions) error {
+	opts.CacheBusting.Apply(msg.Request)
+
+	return nil
+}
+
+// DecompressionMiddleware reads the response body and decodes it according to its Content-Encoding.
+type DecompressionMiddleware struct{}
+
+func (DecompressionMiddleware) ProcessResponse(c *HttpClient, msg *MessageDuplex, opts ClientOptions) (*FollowUp, error) {
+	if msg.ResponseBody == nil {
+		return nil, nil
+	}
+
+	var reader io.Reader
+	switch msg.Response.Header.Get("Content-Encoding") {
+	case "gzip":
+		gzipReader, readErr := gzip.NewReader(msg.ResponseBody.Reader())
+		if readErr != nil {
+			break
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	case "br":
+		reader = brotli.NewReader(msg.ResponseBody.Reader())
+	case "deflate":
+		flateReader := flate.NewReader(msg.ResponseBody.Reader())
+		defer flateReader.Close()
+		reader = flateReader
+	}
+
+	if reader == nil {
+		msg.Response.ContentLength = msg.ResponseBody.Len()
+		return nil, nil
+	}
+
+	body, dcprsErr := c.MessageLog.bodies.store(reader)
+	msg.setResponseBody(body)
+	msg.Response.ContentLength = body.Len()
+
+	if dcprsErr != nil {
+		return nil, fmt.Errorf("error while reading response %w", dcprsErr)
+	}
+
+	return nil, nil
+}
+
+// ErrorHandlingMiddleware keeps track of http errors and applies the configured threshold actions.
+type ErrorHandlingMiddleware struct{}
+
+func (ErrorHandlingMiddleware) ProcessResponse(c *HttpClient, msg *MessageDuplex, opts ClientOptions) (*FollowUp, error) {
+	failed := msg.TransportError != NoError || (msg.Response.StatusCode >= 400 && opts.ErrorHandling.Matches(msg.Response.StatusCode)) ||
+		(msg.Waf != nil && opts.WafDetection.CountAsError)
+
+	counts := c.recordResult(msg, failed)
+	if failed {
+		c.handleHttpError(msg, counts)
+	} else if msg.Waf == nil {
+		c.setBaseline(msg)
+	}
+
+	return nil, nil
+}
+
+// RedirectMiddleware follows redirects according to the RedirectionOptions.
+type RedirectMiddleware struct{}
+
+func (RedirectMiddleware) ProcessResponse(c *HttpClient, msg *MessageDuplex, opts ClientOptions) (*FollowUp, error) {
+	if msg.Response.StatusCode < 300 || msg.Response.StatusCode > 399 {
+		return nil, nil
+	}
+
+	if msg.Response.Request == nil {
+		msg.Response.Request = msg.Request
+	}
+
+	absRedirect := GetRedirectLocation(msg.Response)
+
+	if opts.Redirection.PreventCrossOriginRedirects && IsCrossOrigin(msg.Request.URL.String(), absRedirect) {
+		return nil, nil
+	}
+
+	if opts.Redirection.PreventCrossSiteRedirects && IsCrossSite(msg.Request.URL.String(), absRedirect) {
+		return nil, nil
+	}
+
+	opts.Redirection.currentDepth++
+	if opts.Redirection.currentDepth > opts.Redirection.MaxRedirects {
+		return nil, nil
+	}
+
+	if !opts.Redirection.FollowRedirects {
+		return nil, nil
+	}
+
+	redirectedReq := msg.Request.Clone(msg.Request.Context())
+	opts.CacheBusting.Clear(redirectedReq)
+
+	absRedirectUrl, _ := url.Parse(absRedirect)
+	redirectedReq.Host = absRedirectUrl.Host
+	redirectedReq.URL, _ = url.Parse(absRedirect)
+
+	return &FollowUp{Request: redirectedReq, Options: opts, Redirect: true}, nil
+}
+
+// ReplayRateLimitedMiddleware replays requests that received a 429 or 529 response
+// if ReplayRateLimitted is set.
+type ReplayRateLimitedMiddleware struct{}
+
+func (ReplayRateLimitedMiddleware) ProcessResponse(c *HttpClient, msg *MessageDuplex, opts ClientOptions) (*FollowUp, error) {
+	if msg.Response.StatusCode != 429 && msg.Response.StatusCode != 529 {
+		return nil, nil
+	}
+
+	if !opts.Performance.ReplayRateLimitted {
+		return nil, nil
+	}
+
+	return &FollowUp{Request: msg.Request.Clone(msg.Request.Context()), Options: opts}, nil
+}
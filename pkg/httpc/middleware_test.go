@@ -0,0 +1,131 @@
+package httpc_test
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aristosMiliaressis/httpc/pkg/httpc"
+	"github.com/aristosMiliaressis/httpc/pkg/httpctest"
+)
+
+// callLog records which middlewares ran for which paths, in order.
+type callLog struct {
+	mutex sync.Mutex
+	calls []string
+}
+
+func (l *callLog) add(name string, msg *httpc.MessageDuplex) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.calls = append(l.calls, name+" "+msg.Request.URL.Path)
+}
+
+func (l *callLog) String() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return strings.Join(l.calls, ", ")
+}
+
+func (l *callLog) request(name string, err error) httpc.RequestMiddleware {
+	return httpc.RequestMiddlewareFunc(func(c *httpc.HttpClient, msg *httpc.MessageDuplex, opts httpc.ClientOptions) error {
+		l.add(name, msg)
+		return err
+	})
+}
+
+func (l *callLog) response(name string, process func(msg *httpc.MessageDuplex, opts httpc.ClientOptions) (*httpc.FollowUp, error)) httpc.ResponseMiddleware {
+	return httpc.ResponseMiddlewareFunc(func(c *httpc.HttpClient, msg *httpc.MessageDuplex, opts httpc.ClientOptions) (*httpc.FollowUp, error) {
+		l.add(name, msg)
+		if process == nil {
+			return nil, nil
+		}
+		return process(msg, opts)
+	})
+}
+
+func TestMiddlewareChain(t *testing.T) {
+	errStop := errors.New("stop")
+
+	tests := []struct {
+		name string
+		// first is the first custom response middleware, the second one only logs its call
+		first      func(msg *httpc.MessageDuplex, opts httpc.ClientOptions) (*httpc.FollowUp, error)
+		requestErr error
+		want       string
+		wantPath   string
+		wantError  httpc.TransportError
+		wantPrev   bool
+	}{
+		{
+			name:     "in order",
+			want:     "req1 /a, req2 /a, resp1 /a, resp2 /a",
+			wantPath: "/a",
+		},
+		{
+			name:       "request error stops the chain without sending",
+			requestErr: errStop,
+			want:       "req1 /a",
+			wantPath:   "/a",
+			wantError:  httpc.UnknownError,
+		},
+		{
+			name:     "response error stops the chain",
+			first:    func(msg *httpc.MessageDuplex, opts httpc.ClientOptions) (*httpc.FollowUp, error) { return nil, errStop },
+			want:     "req1 /a, req2 /a, resp1 /a",
+			wantPath: "/a",
+		},
+		{
+			name: "follow-up goes through both chains again",
+			first: func(msg *httpc.MessageDuplex, opts httpc.ClientOptions) (*httpc.FollowUp, error) {
+				if msg.Request.URL.Path != "/a" {
+					return nil, nil
+				}
+				req, _ := http.NewRequest("GET", strings.Replace(msg.Request.URL.String(), "/a", "/b", 1), nil)
+				return &httpc.FollowUp{Request: req, Options: opts}, nil
+			},
+			want:     "req1 /a, req2 /a, resp1 /a, req1 /b, req2 /b, resp1 /b, resp2 /b",
+			wantPath: "/b",
+		},
+		{
+			name: "redirect follow-up is linked to the message",
+			first: func(msg *httpc.MessageDuplex, opts httpc.ClientOptions) (*httpc.FollowUp, error) {
+				if msg.Request.URL.Path != "/a" {
+					return nil, nil
+				}
+				req, _ := http.NewRequest("GET", strings.Replace(msg.Request.URL.String(), "/a", "/b", 1), nil)
+				return &httpc.FollowUp{Request: req, Options: opts, Redirect: true}, nil
+			},
+			want:     "req1 /a, req2 /a, resp1 /a, req1 /b, req2 /b, resp1 /b, resp2 /b",
+			wantPath: "/b",
+			wantPrev: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httpctest.NewServer()
+			defer srv.Close()
+
+			c := httpctest.NewClient(t, nil)
+			log := &callLog{}
+			c.UseRequestMiddleware(log.request("req1", tt.requestErr), log.request("req2", nil))
+			c.UseResponseMiddleware(log.response("resp1", tt.first), log.response("resp2", nil))
+
+			msg := httpctest.Send(t, c, "GET", srv.URL+"/a", "")
+			if got := log.String(); got != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+			if msg.Request.URL.Path != tt.wantPath || msg.TransportError != tt.wantError {
+				t.Fatalf("expected %s with %s, got %s with %s", tt.wantPath, tt.wantError, msg.Request.URL.Path, msg.TransportError)
+			}
+			if (msg.Prev != nil) != tt.wantPrev {
+				t.Fatalf("expected the follow-up to be linked: %v", tt.wantPrev)
+			}
+		})
+	}
+}
@@ -1,8 +1,11 @@
 package httpc
 
 import (
-	"github.com/projectdiscovery/rawhttp"
+	"time"
+
+	"github.com/aristosMiliaressis/httpc/internal/rate"
 	"github.com/aristosMiliaressis/httpc/internal/util"
+	"github.com/projectdiscovery/rawhttp"
 )
 
 type ClientOptions struct {
@@ -12,12 +15,18 @@ type ClientOptions struct {
 	DefaultHeaders          map[string]string
 	RequestPriority         Priority
 	ExcludeCookies          []string
+	// Tags are attached to the messages of requests sent with these options
+	Tags []string `json:",omitempty"`
 
 	Connection    ConnectionOptions
 	CacheBusting  CacheBustingOptions
 	Redirection   RedirectionOptions
 	Performance   PerformanceOptions
 	ErrorHandling ErrorHandlingOptions
+	WafDetection  WafDetectionOptions
+	MessageLog    MessageLogOptions
+	Recorder      RecorderOptions
+	Fixtures      FixtureOptions
 	RawHttp       rawhttp.Options
 }
 
@@ -39,23 +48,111 @@ type RedirectionOptions struct {
 }
 
 type PerformanceOptions struct {
-	Timeout            int
-	RequestsPerSecond  int
+	Timeout               int
+	RequestsPerSecond     float64
+	Burst                 int
+	MaxConcurrency        int
+	MaxConcurrencyPerHost int
+	BatchWindow           int
+	// Delay is a random pause in seconds a worker takes after each request before its slot
+	// is released, it paces each worker rather than the client so MaxConcurrency workers
+	// together still send up to MaxConcurrency requests per Delay
 	Delay              Range
 	AutoRateThrottle   bool
 	ReplayRateLimitted bool
 }
 
 type ErrorHandlingOptions struct {
-	PercentageThreshold      int
-	ConsecutiveThreshold     int
-	VerifyIPBanIfExheeded    bool
-	IpRotateIfExheeded       bool
-	ReportErrorsIfExheeded   bool
-	RetryTransportFailures   bool
+	PercentageThreshold    int
+	ConsecutiveThreshold   int
+	VerifyIPBanIfExheeded  bool
+	IpRotateIfExheeded     bool
+	ReportErrorsIfExheeded bool
+	PauseIfExheeded        bool
+	// RetryTransportFailures retries requests failing with a transport error up to MaxRetries times,
+	// MaxRetries has to be set as well when the options aren't derived from DefaultOptions
+	RetryTransportFailures bool
+	// MaxRetries is how often a request failing with a transport error is retried when
+	// RetryTransportFailures is set, zero means it is not retried
+	MaxRetries               int
 	HandleErrorCodes         []int
 	ReverseErrorCodeHandling bool
 	AwsProfile               string
+
+	// ErrorWindow and ErrorWindowDuration limit the percentage threshold to the most recent requests
+	// and to requests completed within the duration, if both are zero every request is counted
+	ErrorWindow         int
+	ErrorWindowDuration time.Duration
+	// PercentageMinRequests is how many requests the window must exceed before the percentage threshold is checked
+	PercentageMinRequests int
+	// RecoveryPercentage is the error percentage a host must drop below to be considered recovered
+	// after exceeding the percentage threshold, zero uses PercentageThreshold
+	RecoveryPercentage int
+	// BanCooldown is how long a host is paused once an ip ban is confirmed before it is verified again,
+	// zero halts the host instead
+	BanCooldown time.Duration
+
+	CircuitBreaker CircuitBreakerOptions
+}
+
+type CircuitBreakerOptions struct {
+	// FailureThreshold is how many consecutive transport failures open a host's circuit,
+	// zero disables the circuit breaker
+	FailureThreshold int
+	// Cooldown is how long requests to a host with an open circuit fail fast
+	Cooldown time.Duration
+	// Probes is how many requests are let through after the cooldown,
+	// the circuit closes if all of them succeed and opens again otherwise
+	Probes int
+}
+
+type WafDetectionOptions struct {
+	Enabled bool
+	// CountAsError counts detected responses towards the error thresholds and ip ban handling
+	CountAsError bool
+	// ThrottleOnChallenge counts challenge and captcha responses like 429s when auto rate throttling
+	ThrottleOnChallenge bool
+	// Signatures are matched before DefaultWafSignatures
+	Signatures []WafSignature
+}
+
+type MessageLogOptions struct {
+	// MaxMessages is how many of the most recent messages are kept in memory, zero keeps every message.
+	// Bodies kept in files are removed with the messages that are dropped or spilled
+	MaxMessages int
+	// SpillDir is where messages evicted from memory are written to, if empty they are dropped
+	SpillDir string
+	// MaxBodyMemory is the size in bytes above which bodies are kept in files in SpillDir or the
+	// temporary directory instead of memory, zero keeps every body in memory.
+	// The files are removed when the message log is closed.
+	MaxBodyMemory int64
+}
+
+type RecorderOptions struct {
+	// Path is the file completed messages are written to as JSON lines, recording is disabled if empty
+	Path string
+	// MaxSize is the size in bytes after which the file is rotated, zero disables rotation
+	MaxSize int64
+	// MaxBackups is how many rotated files are kept, zero keeps every file
+	MaxBackups int
+	// Compress gzips rotated files
+	Compress bool
+	// InlineBodyLimit is the size in bytes up to which bodies are written inline,
+	// larger bodies are referenced by their sha256 hash, negative always inlines bodies
+	InlineBodyLimit int
+	// BodyDir is where bodies referenced by hash are written to, if empty they are omitted
+	BodyDir string
+}
+
+type FixtureOptions struct {
+	// Mode is whether requests are recorded to or replayed from the fixture file
+	Mode FixtureMode
+	// Path is the fixture file
+	Path string
+	// IgnoreParams are query parameters left out of the default fixture key, e.g. cache busters
+	IgnoreParams []string
+	// Key overrides how requests are matched to fixtures
+	Key FixtureKey `json:"-"`
 }
 
 type CacheBustingOptions struct {
@@ -80,6 +177,10 @@ type Range struct {
 
 type Priority int
 
+// UnlimitedRate disables rate limiting when used as PerformanceOptions.RequestsPerSecond,
+// fractional rates (e.g. 0.2) are also supported for fragile targets.
+const UnlimitedRate = rate.Unlimited
+
 var DefaultOptions = ClientOptions{
 	SimulateBrowserRequests: true,
 	MaintainCookieJar:       true,
@@ -96,6 +197,8 @@ var DefaultOptions = ClientOptions{
 	Performance: PerformanceOptions{
 		Timeout:            10,
 		RequestsPerSecond:  10,
+		Burst:              1,
+		BatchWindow:        100,
 		AutoRateThrottle:   true,
 		ReplayRateLimitted: true,
 		Delay:              Range{Min: 0, Max: 0.1},
@@ -105,7 +208,30 @@ var DefaultOptions = ClientOptions{
 		ConsecutiveThreshold:   0,
 		VerifyIPBanIfExheeded:  true,
 		ReportErrorsIfExheeded: true,
+		MaxRetries:             3,
 		HandleErrorCodes:       []int{401, 402, 404, 405, 406, 407, 410, 411, 412, 413, 414, 415, 416, 417, 426, 431, 500, 501},
+		ErrorWindow:            100,
+		PercentageMinRequests:  40,
+		RecoveryPercentage:     50,
+		BanCooldown:            5 * time.Minute,
+		CircuitBreaker: CircuitBreakerOptions{
+			Cooldown: 30 * time.Second,
+			Probes:   1,
+		},
+	},
+	WafDetection: WafDetectionOptions{
+		Enabled: true,
+	},
+	MessageLog: MessageLogOptions{
+		MaxBodyMemory: 10 * 1024 * 1024,
+	},
+	Recorder: RecorderOptions{
+		MaxSize:         100 * 1024 * 1024,
+		Compress:        true,
+		InlineBodyLimit: 64 * 1024,
+	},
+	Fixtures: FixtureOptions{
+		IgnoreParams: []string{DefaultCacheBusterParam},
 	},
 	RawHttp: rawhttp.Options{
 		AutomaticHostHeader: false,
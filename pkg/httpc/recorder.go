@@ -0,0 +1,471 @@
+package httpc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/projectdiscovery/gologger"
+)
+
+const recorderTimeFormat = "20060102T150405.000"
+
+// Recorder writes completed messages to a JSON lines file, rotating it once it exceeds MaxSize.
+type Recorder struct {
+	mutex sync.Mutex
+	opts  RecorderOptions
+
+	file *os.File
+	size int64
+	// rotations tracks the compression and pruning of rotated files
+	rotations sync.WaitGroup
+}
+
+// recordedMessage is a line of a recording.
+type recordedMessage struct {
+	Timestamp      time.Time
+	Duration       time.Duration
+	TransportError TransportError
+	Error          string            `json:",omitempty"`
+	Tags           []string          `json:",omitempty"`
+	Request        *recordedRequest  `json:",omitempty"`
+	Response       *recordedResponse `json:",omitempty"`
+	Waf            *WafDetection     `json:",omitempty"`
+	Prev           *recordedMessage  `json:",omitempty"`
+}
+
+type recordedRequest struct {
+	Method string
+	Url    string
+	Host   string `json:",omitempty"`
+	Proto  string
+	Header http.Header
+	recordedBody
+}
+
+type recordedResponse struct {
+	StatusCode int
+	Status     string
+	Proto      string
+	Header     http.Header
+	recordedBody
+}
+
+// recordedBody holds either the body itself or the sha256 hash of bodies larger than InlineBodyLimit.
+type recordedBody struct {
+	Body     []byte `json:",omitempty"`
+	BodyHash string `json:",omitempty"`
+	BodySize int
+}
+
+func NewRecorder(opts RecorderOptions) (*Recorder, error) {
+	if opts.BodyDir != "" {
+		if err := os.MkdirAll(opts.BodyDir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &Recorder{opts: opts}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *Recorder) open() error {
+	file, err := os.OpenFile(r.opts.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	r.file = file
+	r.size = info.Size()
+
+	return nil
+}
+
+// Record writes a message and its redirect chain as one line.
+func (r *Recorder) Record(msg *MessageDuplex) error {
+	recorded, err := r.encode(msg)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(recorded)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.file == nil {
+		return os.ErrClosed
+	}
+
+	if r.opts.MaxSize > 0 && r.size > 0 && r.size+int64(len(line)) > r.opts.MaxSize {
+		if err := r.rotate(); err != nil {
+			gologger.Error().Msgf("failed to rotate recording %s: %s", r.opts.Path, err)
+		}
+	}
+
+	n, err := r.file.Write(line)
+	r.size += int64(n)
+
+	return err
+}
+
+// rotate renames the current file with a timestamp and sequence number suffix
+// and starts a new one, must be called with the lock held.
+func (r *Recorder) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+
+	rotated := rotatedName(r.opts.Path, time.Now())
+	if err := os.Rename(r.opts.Path, rotated); err != nil {
+		return errors.Join(err, r.open())
+	}
+
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	r.rotations.Add(1)
+	go func() {
+		defer r.rotations.Done()
+		if r.opts.Compress {
+			if err := compressFile(rotated); err != nil {
+				gologger.Error().Msgf("failed to compress recording %s: %s", rotated, err)
+			}
+		}
+		r.removeBackups()
+	}()
+
+	return nil
+}
+
+// rotatedName returns the first unused name for a file rotated at the given time,
+// the sequence number keeps files rotated within the same millisecond apart.
+func rotatedName(path string, now time.Time) string {
+	ext := filepath.Ext(path)
+	prefix := fmt.Sprintf("%s-%s", strings.TrimSuffix(path, ext), now.Format(recorderTimeFormat))
+
+	for seq := 0; ; seq++ {
+		rotated := fmt.Sprintf("%s-%03d%s", prefix, seq, ext)
+		if !fileExists(rotated) && !fileExists(rotated+".gz") {
+			return rotated
+		}
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// removeBackups removes the oldest rotated files beyond MaxBackups.
+func (r *Recorder) removeBackups() {
+	if r.opts.MaxBackups <= 0 {
+		return
+	}
+
+	backups := recordingBackups(r.opts.Path)
+	for len(backups) > r.opts.MaxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+}
+
+// recordingBackups returns the rotated files of a recording, oldest first,
+// a rotated file is skipped if its compressed copy already exists.
+func recordingBackups(path string) []string {
+	ext := filepath.Ext(path)
+	matches, _ := filepath.Glob(strings.TrimSuffix(path, ext) + "-*" + ext + "*")
+
+	backups := []string{}
+	for _, match := range matches {
+		switch {
+		case strings.HasSuffix(match, ext+".gz"):
+			backups = append(backups, match)
+		case strings.HasSuffix(match, ext) && !fileExists(match+".gz"):
+			backups = append(backups, match)
+		}
+	}
+	sort.Strings(backups)
+
+	return backups
+}
+
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// the compressed copy only gets its final name once complete, so readers never see a partial one
+	tmp := path + ".gz.tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if err = errors.Join(err, gz.Close(), dst.Close()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// Close closes the current file and waits for rotated files to be compressed,
+// messages recorded afterwards are dropped.
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	var err error
+	if r.file != nil {
+		err = r.file.Close()
+		r.file = nil
+	}
+	r.mutex.Unlock()
+
+	r.rotations.Wait()
+
+	return err
+}
+
+func (r *Recorder) encode(msg *MessageDuplex) (*recordedMessage, error) {
+	recorded := &recordedMessage{
+		Timestamp:      msg.Timestamp,
+		Duration:       msg.Duration,
+		TransportError: msg.TransportError,
+		Tags:           msg.Tags,
+		Waf:            msg.Waf,
+	}
+
+	if msg.Error != nil {
+		recorded.Error = msg.Error.Error()
+	}
+
+	if msg.Request != nil {
+		recorded.Request = &recordedRequest{
+			Method: msg.Request.Method,
+			Url:    msg.Request.URL.String(),
+			Host:   msg.Request.Host,
+			Proto:  msg.Request.Proto,
+			Header: msg.Request.Header,
+		}
+		data, err := msg.requestBytes()
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			recorded.Request.recordedBody = r.encodeBody(data)
+		}
+	}
+
+	if msg.Response != nil {
+		recorded.Response = &recordedResponse{
+			StatusCode: msg.Response.StatusCode,
+			Status:     msg.Response.Status,
+			Proto:      msg.Response.Proto,
+			Header:     msg.Response.Header,
+		}
+		data, err := msg.responseBytes()
+		if err != nil {
+			return nil, err
+		}
+		recorded.Response.recordedBody = r.encodeBody(data)
+	}
+
+	if msg.Prev != nil {
+		prev, err := r.encode(msg.Prev)
+		if err != nil {
+			return nil, err
+		}
+		recorded.Prev = prev
+	}
+
+	return recorded, nil
+}
+
+func (r *Recorder) encodeBody(data []byte) recordedBody {
+	if r.opts.InlineBodyLimit < 0 || len(data) <= r.opts.InlineBodyLimit {
+		return recordedBody{Body: data, BodySize: len(data)}
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	if r.opts.BodyDir != "" {
+		path := filepath.Join(r.opts.BodyDir, hash)
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				gologger.Error().Msgf("failed to write body %s: %s", path, err)
+			}
+		}
+	}
+
+	return recordedBody{BodyHash: "sha256:" + hash, BodySize: len(data)}
+}
+
+// LoadRecording reads the rotated files and the current file of a recording, oldest first.
+func LoadRecording(opts RecorderOptions) (MessageLog, error) {
+	paths := recordingBackups(opts.Path)
+	if _, err := os.Stat(opts.Path); err == nil {
+		paths = append(paths, opts.Path)
+	}
+
+	log := MessageLog{}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		messages, err := ReadRecording(file, opts.BodyDir)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read recording %s: %w", path, err)
+		}
+		log = append(log, messages...)
+	}
+
+	return log, nil
+}
+
+// ReadRecording reads a plain or gzipped recording, bodies referenced by hash are
+// loaded from bodyDir and left empty if they are not found there.
+func ReadRecording(r io.Reader, bodyDir string) (MessageLog, error) {
+	reader := bufio.NewReader(r)
+	if magic, _ := reader.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = bufio.NewReader(gz)
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, 64*1024*1024)
+
+	log := MessageLog{}
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var recorded recordedMessage
+		if err := json.Unmarshal(scanner.Bytes(), &recorded); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		log = append(log, recorded.decode(bodyDir))
+	}
+
+	return log, scanner.Err()
+}
+
+func (recorded *recordedMessage) decode(bodyDir string) *MessageDuplex {
+	msg := &MessageDuplex{
+		Timestamp:      recorded.Timestamp,
+		Duration:       recorded.Duration,
+		TransportError: recorded.TransportError,
+		Tags:           recorded.Tags,
+		Waf:            recorded.Waf,
+	}
+
+	if recorded.Error != "" {
+		msg.Error = errors.New(recorded.Error)
+	}
+
+	if recorded.Request != nil {
+		body := recorded.Request.body(bodyDir)
+		u, err := url.Parse(recorded.Request.Url)
+		if err != nil {
+			u = &url.URL{}
+		}
+		msg.Request = &http.Request{
+			Method: recorded.Request.Method,
+			URL:    u,
+			Host:   recorded.Request.Host,
+			Proto:  recorded.Request.Proto,
+			Header: recorded.Request.Header,
+		}
+		msg.Request.ProtoMajor, msg.Request.ProtoMinor, _ = http.ParseHTTPVersion(msg.Request.Proto)
+		if msg.Request.Header == nil {
+			msg.Request.Header = http.Header{}
+		}
+		msg.Request = msg.Request.WithContext(context.Background())
+		msg.setRequestBody(NewBody(body))
+	}
+
+	if recorded.Response != nil {
+		body := recorded.Response.body(bodyDir)
+		msg.Response = &http.Response{
+			Status:        recorded.Response.Status,
+			StatusCode:    recorded.Response.StatusCode,
+			Proto:         recorded.Response.Proto,
+			Header:        recorded.Response.Header,
+			ContentLength: int64(len(body)),
+			Request:       msg.Request,
+		}
+		msg.Response.ProtoMajor, msg.Response.ProtoMinor, _ = http.ParseHTTPVersion(msg.Response.Proto)
+		if msg.Response.Header == nil {
+			msg.Response.Header = http.Header{}
+		}
+		msg.setResponseBody(NewBody(body))
+	}
+
+	if recorded.Prev != nil {
+		msg.Prev = recorded.Prev.decode(bodyDir)
+	}
+
+	return msg
+}
+
+func (b recordedBody) body(bodyDir string) []byte {
+	if b.BodyHash == "" || bodyDir == "" {
+		return b.Body
+	}
+
+	data, err := os.ReadFile(filepath.Join(bodyDir, strings.TrimPrefix(b.BodyHash, "sha256:")))
+	if err != nil {
+		gologger.Debug().Msgf("failed to load body %s: %s", b.BodyHash, err)
+		return nil
+	}
+
+	return data
+}
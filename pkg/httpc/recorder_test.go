@@ -0,0 +1,135 @@
+package httpc
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecorderRotation(t *testing.T) {
+	dir := t.TempDir()
+	opts := RecorderOptions{Path: filepath.Join(dir, "messages.jsonl"), MaxSize: 1000, MaxBackups: 3, Compress: true, InlineBodyLimit: -1}
+
+	r, err := NewRecorder(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := r.Record(newTestMessage(t, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Close waits for the rotated files to be compressed and pruned
+	backups := recordingBackups(opts.Path)
+	if len(backups) != opts.MaxBackups {
+		t.Fatalf("expected %d backups, got %v", opts.MaxBackups, backups)
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if entry.Name() != "messages.jsonl" && !strings.HasSuffix(entry.Name(), ".jsonl.gz") {
+			t.Fatalf("expected only compressed backups besides the current file, found %s", entry.Name())
+		}
+	}
+
+	log, err := LoadRecording(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log) == 0 || log[len(log)-1].Tags[0] != "19" {
+		t.Fatalf("expected the recording to end with the last message, got %d messages", len(log))
+	}
+	for i := 1; i < len(log); i++ {
+		prev, _ := strconv.Atoi(log[i-1].Tags[0])
+		if current, _ := strconv.Atoi(log[i].Tags[0]); current != prev+1 {
+			t.Fatalf("expected consecutive messages in recording order, got %d after %d", current, prev)
+		}
+	}
+
+	msg := log[len(log)-1]
+	if msg.Request.Method != "POST" || msg.RequestBody.String() != "request 19" || msg.ResponseBody.String() != "response 19" {
+		t.Fatalf("expected the message to be restored, got %s %q %q", msg.Request.Method, msg.RequestBody.String(), msg.ResponseBody.String())
+	}
+}
+
+func TestRotatedNameSequence(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "messages.jsonl")
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	first := rotatedName(path, now)
+	if err := os.WriteFile(first, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	second := rotatedName(path, now)
+	if err := os.WriteFile(second+".gz", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	third := rotatedName(path, now)
+
+	if first == second || second == third || first == third {
+		t.Fatalf("expected files rotated within the same millisecond to get distinct names, got %s, %s, %s", first, second, third)
+	}
+	if !(first < second && second < third) {
+		t.Fatalf("expected names to sort in rotation order, got %s, %s, %s", first, second, third)
+	}
+}
+
+func TestRecordingBackupsSkipsCompressedTwins(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "messages.jsonl")
+
+	for _, name := range []string{"messages-1.jsonl", "messages-1.jsonl.gz", "messages-2.jsonl", "messages-3.jsonl.gz.tmp", "other-1.jsonl"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backups := recordingBackups(path)
+	want := []string{filepath.Join(dir, "messages-1.jsonl.gz"), filepath.Join(dir, "messages-2.jsonl")}
+	if strings.Join(backups, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected %v, got %v", want, backups)
+	}
+}
+
+func TestRecorderBodiesByHash(t *testing.T) {
+	dir := t.TempDir()
+	opts := RecorderOptions{Path: filepath.Join(dir, "messages.jsonl"), InlineBodyLimit: 4, BodyDir: filepath.Join(dir, "bodies")}
+
+	r, err := NewRecorder(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Record(newTestMessage(t, 1))
+	r.Close()
+
+	data, _ := os.ReadFile(opts.Path)
+	if strings.Contains(string(data), "response 1") || !strings.Contains(string(data), "sha256:") {
+		t.Fatalf("expected bodies above the inline limit to be referenced by hash, got %s", data)
+	}
+
+	log, err := LoadRecording(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log) != 1 || log[0].ResponseBody.String() != "response 1" {
+		t.Fatal("expected the body to be loaded from the body directory")
+	}
+
+	// without the body directory the body is left empty
+	file, _ := os.Open(opts.Path)
+	defer file.Close()
+	log, err = ReadRecording(file, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log[0].ResponseBody.Len() != 0 {
+		t.Fatalf("expected an empty body, got %q", log[0].ResponseBody.String())
+	}
+}
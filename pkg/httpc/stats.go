@@ -0,0 +1,167 @@
+package httpc
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const latencySamples = 1000
+
+// Stats is a snapshot of the client's statistics, it can be marshaled to JSON.
+type Stats struct {
+	// Messages is the number of messages processed, including retried attempts and redirects
+	Messages        int
+	Errors          ErrorCounts
+	StatusCodes     map[int]int
+	TransportErrors map[TransportError]int
+	Hosts           map[string]HostStats
+
+	DesiredRate  float64
+	ThrottleRate float64
+	CurrentRate  float64
+	Latency      LatencyStats
+
+	Queued   int
+	InFlight int
+}
+
+type HostStats struct {
+	Messages        int
+	Errors          ErrorCounts
+	StatusCodes     map[int]int
+	TransportErrors map[TransportError]int
+}
+
+// LatencyStats are time to first byte percentiles over the most recent responses.
+type LatencyStats struct {
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+	Max time.Duration
+}
+
+// statsCollector keeps the message counts and latency samples the statistics are built from.
+type statsCollector struct {
+	mutex sync.Mutex
+
+	messages        int
+	statusCodes     map[int]int
+	transportErrors map[TransportError]int
+	hosts           map[string]*hostCounts
+
+	latencies    [latencySamples]time.Duration
+	latencyCount int
+}
+
+type hostCounts struct {
+	messages        int
+	statusCodes     map[int]int
+	transportErrors map[TransportError]int
+}
+
+func newStatsCollector() *statsCollector {
+	return &statsCollector{
+		statusCodes:     map[int]int{},
+		transportErrors: map[TransportError]int{},
+		hosts:           map[string]*hostCounts{},
+	}
+}
+
+func (s *statsCollector) record(msg *MessageDuplex) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	host := ""
+	if msg.Request != nil {
+		host = msg.Request.URL.Host
+	}
+
+	counts, ok := s.hosts[host]
+	if !ok {
+		counts = &hostCounts{statusCodes: map[int]int{}, transportErrors: map[TransportError]int{}}
+		s.hosts[host] = counts
+	}
+
+	s.messages++
+	counts.messages++
+
+	if msg.TransportError != NoError {
+		s.transportErrors[msg.TransportError]++
+		counts.transportErrors[msg.TransportError]++
+		return
+	}
+
+	if msg.Response == nil {
+		return
+	}
+
+	s.statusCodes[msg.Response.StatusCode]++
+	counts.statusCodes[msg.Response.StatusCode]++
+
+	s.latencies[s.latencyCount%latencySamples] = msg.Duration
+	s.latencyCount++
+}
+
+func (s *statsCollector) latency() LatencyStats {
+	samples := make([]time.Duration, min(s.latencyCount, latencySamples))
+	copy(samples, s.latencies[:len(samples)])
+	if len(samples) == 0 {
+		return LatencyStats{}
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	percentile := func(p int) time.Duration {
+		return samples[(len(samples)-1)*p/100]
+	}
+
+	return LatencyStats{
+		P50: percentile(50),
+		P90: percentile(90),
+		P99: percentile(99),
+		Max: samples[len(samples)-1],
+	}
+}
+
+// Stats returns a snapshot of the client's statistics.
+func (c *HttpClient) Stats() Stats {
+	c.stats.mutex.Lock()
+	stats := Stats{
+		Messages:        c.stats.messages,
+		StatusCodes:     copyCounts(c.stats.statusCodes),
+		TransportErrors: copyCounts(c.stats.transportErrors),
+		Hosts:           make(map[string]HostStats, len(c.stats.hosts)),
+		Latency:         c.stats.latency(),
+	}
+	for host, counts := range c.stats.hosts {
+		stats.Hosts[host] = HostStats{
+			Messages:        counts.messages,
+			StatusCodes:     copyCounts(counts.statusCodes),
+			TransportErrors: copyCounts(counts.transportErrors),
+		}
+	}
+	c.stats.mutex.Unlock()
+
+	stats.Errors = c.ErrorCounts()
+	for host, hostStats := range stats.Hosts {
+		hostStats.Errors = c.HostErrorCounts(host)
+		stats.Hosts[host] = hostStats
+	}
+
+	stats.DesiredRate = c.ThreadPool.Rate.RPS()
+	stats.ThrottleRate = c.ThreadPool.Rate.GetThrottleRate()
+	stats.CurrentRate = c.ThreadPool.Rate.CurrentRate()
+	stats.Queued = c.ThreadPool.getPendingCount()
+	stats.InFlight = c.ThreadPool.GetThreadCount()
+
+	return stats
+}
+
+func copyCounts[K comparable](counts map[K]int) map[K]int {
+	copied := make(map[K]int, len(counts))
+	for k, v := range counts {
+		copied[k] = v
+	}
+
+	return copied
+}
@@ -2,7 +2,6 @@ package httpc
 
 import (
 	"context"
-	"fmt"
 	"math/rand"
 	"sort"
 	"sync"
@@ -16,8 +15,17 @@ type PendingRequest struct {
 	RawRequest string
 	Message    *MessageDuplex
 	Options    ClientOptions
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	future  *Future
+	retries int
+	host    string
+	nested  bool
+	err     error
 }
-type RequestQueue chan PendingRequest
+
+type RequestQueue []PendingRequest
 
 type ThreadPool struct {
 	Rate     *rate.RateThrottle
@@ -25,140 +33,440 @@ type ThreadPool struct {
 	maxDelay float64
 	context  context.Context
 
-	queuePriorityMap   map[Priority]RequestQueue
-	queuePriorityMutex sync.RWMutex
-	queueBufferSize    int
+	queuePriorityMap   map[Priority]*RequestQueue
+	queuePriorities    []Priority
+	queuePriorityMutex sync.Mutex
+	pendingCount       int
+	paused             bool
+	pausedHosts        map[string]bool
+	workSignal         chan struct{}
+	stateChanged       chan struct{}
+
+	totalThreads          int
+	lockedThreads         int
+	hostThreads           map[string]int
+	maxConcurrency        int
+	maxConcurrencyPerHost int
 
-	totalThreads chan bool
-	lockedThreads chan bool
-	threadLimiter bool
-	
 	processCallback func(uow PendingRequest)
-}
 
-func (tp *ThreadPool) NewRequestQueue() RequestQueue {
-	return make(RequestQueue, tp.queueBufferSize)
+	// OnRateChange is called when the desired or throttled rate changes
+	OnRateChange func(rps float64, throttleRate float64)
 }
 
-func NewThreadPool(callback func(uow PendingRequest), context context.Context, rps int, delay Range, bufferSize int) *ThreadPool {
+func NewThreadPool(callback func(uow PendingRequest), context context.Context, opts PerformanceOptions) *ThreadPool {
 	return &ThreadPool{
-		context:          context,
-		queueBufferSize:  bufferSize,
-		processCallback:  callback,
-		minDelay:         delay.Min,
-		maxDelay:         delay.Max,
-		totalThreads:     make(chan bool, bufferSize),
-		lockedThreads:    make(chan bool, bufferSize),
-		Rate:             rate.NewRateThrottle(rps),
-		queuePriorityMap: make(map[Priority]RequestQueue),
+		context:               context,
+		processCallback:       callback,
+		minDelay:              opts.Delay.Min,
+		maxDelay:              opts.Delay.Max,
+		workSignal:            make(chan struct{}, 1),
+		stateChanged:          make(chan struct{}),
+		Rate:                  rate.NewRateThrottle(opts.RequestsPerSecond, opts.Burst),
+		queuePriorityMap:      make(map[Priority]*RequestQueue),
+		hostThreads:           make(map[string]int),
+		pausedHosts:           make(map[string]bool),
+		maxConcurrency:        opts.MaxConcurrency,
+		maxConcurrencyPerHost: opts.MaxConcurrencyPerHost,
 	}
 }
 
+// Run dispatches queued requests to workers, blocking while there is no work
+// and pacing dispatches according to the configured rate.
 func (tp *ThreadPool) Run() {
-
-	for i := 1; true; i++ {
-		select {
-		case <-tp.context.Done():
+	for {
+		if !tp.waitForWork() {
 			return
-		default:
 		}
-		
-		tp.threadLimiter = true
-
-		pending := tp.getPendingCount()
-		
-		throttleRate := tp.Rate.GetThrottleRate()
-
-		gologger.Debug().Msgf("threads: %d, desiredRate: %d, currentRate: %d, throttleRate: %d, delay: %f-%fs, pending: %d\n",
-			len(tp.totalThreads), tp.Rate.RPS, tp.Rate.CurrentRate(), throttleRate, tp.minDelay, tp.maxDelay, pending)
-		
-		<-time.After(time.Millisecond * 1000)
-		if tp.getPendingCount() == 0 {
+
+		uow, ok := tp.dequeue()
+		if !ok {
 			continue
 		}
-		
-		if tp.Rate.CurrentRate() < int64(tp.Rate.RPS) - int64(throttleRate) || len(tp.totalThreads) - len(tp.lockedThreads) == 0 {
-			tp.totalThreads <- true
 
-			go tp.work(i)
+		// the token is only taken once there is a request to spend it on,
+		// if the pool is closed meanwhile the worker completes the request as cancelled
+		if tp.Rate.Wait(tp.context) != nil {
+			go tp.work(uow)
+			return
 		}
+
+		go tp.work(uow)
+
+		gologger.Debug().Msgf("threads: %d, locked: %d, desiredRate: %.2f, currentRate: %.2f, throttleRate: %.2f, pending: %d\n",
+			tp.GetThreadCount(), tp.GetLockedThreadCount(), tp.Rate.RPS(), tp.Rate.CurrentRate(), tp.Rate.GetThrottleRate(), tp.getPendingCount())
+	}
+}
+
+// ChangeRate updates the requests per second limit, taking effect immediately.
+// A non-positive rate disables rate limiting.
+func (tp *ThreadPool) ChangeRate(rps float64) {
+	tp.Rate.ChangeRate(rps)
+	tp.rateChanged()
+}
+
+// SetThrottlePercentage reduces the rate by the given percentage.
+func (tp *ThreadPool) SetThrottlePercentage(percentage uint8) {
+	if tp.Rate.SetRatelimitPercentage(percentage) {
+		tp.rateChanged()
 	}
 }
 
-func (tp *ThreadPool) getNextPrioritizedRequest() PendingRequest {
+func (tp *ThreadPool) rateChanged() {
+	if tp.OnRateChange != nil {
+		tp.OnRateChange(tp.Rate.RPS(), tp.Rate.GetThrottleRate())
+	}
+}
+
+// SetBurst updates the number of requests that can be dispatched at once
+// after the pool has been idle.
+func (tp *ThreadPool) SetBurst(burst int) {
+	tp.Rate.SetBurst(burst)
+}
+
+// SetMaxConcurrency updates the maximum number of requests in flight overall
+// and per host, zero disables the respective limit.
+func (tp *ThreadPool) SetMaxConcurrency(global int, perHost int) {
+	tp.queuePriorityMutex.Lock()
+	tp.maxConcurrency = global
+	tp.maxConcurrencyPerHost = perHost
+	tp.queuePriorityMutex.Unlock()
+
+	tp.signal()
+}
+
+// Pause stops dispatching queued requests, in-flight requests are allowed to finish.
+func (tp *ThreadPool) Pause() {
+	tp.queuePriorityMutex.Lock()
+	defer tp.queuePriorityMutex.Unlock()
+
+	tp.paused = true
+}
+
+// Resume continues dispatching queued requests after a Pause.
+func (tp *ThreadPool) Resume() {
+	tp.queuePriorityMutex.Lock()
+	tp.paused = false
+	tp.queuePriorityMutex.Unlock()
+
+	tp.signal()
+}
+
+// PauseHost stops dispatching queued requests to a host.
+func (tp *ThreadPool) PauseHost(host string) {
+	tp.queuePriorityMutex.Lock()
+	defer tp.queuePriorityMutex.Unlock()
+
+	tp.pausedHosts[host] = true
+}
+
+func (tp *ThreadPool) ResumeHost(host string) {
+	tp.queuePriorityMutex.Lock()
+	delete(tp.pausedHosts, host)
+	tp.queuePriorityMutex.Unlock()
+
+	tp.signal()
+}
+
+func (tp *ThreadPool) IsHostPaused(host string) bool {
+	tp.queuePriorityMutex.Lock()
+	defer tp.queuePriorityMutex.Unlock()
+
+	return tp.pausedHosts[host]
+}
+
+func (tp *ThreadPool) IsPaused() bool {
+	tp.queuePriorityMutex.Lock()
+	defer tp.queuePriorityMutex.Unlock()
+
+	return tp.paused
+}
+
+// GetThreadCount returns the number of requests currently being processed.
+func (tp *ThreadPool) GetThreadCount() int {
+	tp.queuePriorityMutex.Lock()
+	defer tp.queuePriorityMutex.Unlock()
+
+	return tp.totalThreads
+}
+
+// GetLockedThreadCount returns the number of workers waiting on requests they queued themselves.
+func (tp *ThreadPool) GetLockedThreadCount() int {
+	tp.queuePriorityMutex.Lock()
+	defer tp.queuePriorityMutex.Unlock()
+
+	return tp.lockedThreads
+}
+
+// Wait blocks until there are no queued or in-flight requests left,
+// or until either the given or the pool's context is done.
+func (tp *ThreadPool) Wait(ctx context.Context) error {
+	return tp.waitUntil(ctx, func() bool {
+		return tp.pendingCount == 0 && tp.totalThreads == 0
+	})
+}
+
+func (tp *ThreadPool) waitForWorkers(ctx context.Context) {
+	tp.waitUntil(ctx, func() bool {
+		return tp.totalThreads == 0
+	})
+}
+
+// waitUntil blocks until condition, evaluated with queuePriorityMutex held, is met.
+func (tp *ThreadPool) waitUntil(ctx context.Context, condition func() bool) error {
 	for {
-		priorities := []int{}
-		tp.queuePriorityMutex.RLock()
-		for p := range tp.queuePriorityMap {
-			priorities = append(priorities, int(p))
+		tp.queuePriorityMutex.Lock()
+		done := condition()
+		changed := tp.stateChanged
+		tp.queuePriorityMutex.Unlock()
+
+		if done {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// notifyStateChange wakes up goroutines blocked in waitUntil.
+// Must be called with queuePriorityMutex held.
+func (tp *ThreadPool) notifyStateChange() {
+	close(tp.stateChanged)
+	tp.stateChanged = make(chan struct{})
+}
+
+// Enqueue adds a request to the queue of its priority level and wakes up the dispatcher.
+func (tp *ThreadPool) Enqueue(uow PendingRequest) {
+	priority := uow.Options.RequestPriority
+	if uow.Message.Request != nil {
+		uow.host = uow.Message.Request.URL.Host
+	}
+
+	tp.queuePriorityMutex.Lock()
+	queue, ok := tp.queuePriorityMap[priority]
+	if !ok {
+		queue = &RequestQueue{}
+		tp.queuePriorityMap[priority] = queue
+		tp.queuePriorities = append(tp.queuePriorities, priority)
+		sort.Slice(tp.queuePriorities, func(i, j int) bool {
+			return tp.queuePriorities[i] > tp.queuePriorities[j]
+		})
+	}
+	*queue = append(*queue, uow)
+	tp.pendingCount++
+	tp.queuePriorityMutex.Unlock()
+
+	tp.signal()
+}
+
+func (tp *ThreadPool) signal() {
+	select {
+	case tp.workSignal <- struct{}{}:
+	default:
+	}
+}
+
+// waitForWork blocks until there is a queued request that can be dispatched
+// without exceeding the concurrency limits.
+func (tp *ThreadPool) waitForWork() bool {
+	for !tp.hasDispatchable() {
+		select {
+		case <-tp.context.Done():
+			return false
+		case <-tp.workSignal:
 		}
-		tp.queuePriorityMutex.RUnlock()
-		sort.Sort(sort.Reverse(sort.IntSlice(priorities)))
-
-		for _, p := range priorities {
-			tp.queuePriorityMutex.RLock()
-			queue := tp.queuePriorityMap[Priority(p)]
-			tp.queuePriorityMutex.RUnlock()
-			if len(queue) == 0 {
+	}
+
+	select {
+	case <-tp.context.Done():
+		return false
+	default:
+		return true
+	}
+}
+
+func (tp *ThreadPool) hasDispatchable() bool {
+	tp.queuePriorityMutex.Lock()
+	defer tp.queuePriorityMutex.Unlock()
+
+	p, _ := tp.findDispatchable()
+	return p != nil
+}
+
+// findDispatchable returns the queue and index of the highest priority request
+// whose host has spare capacity, while the pool or a host is paused only nested requests are considered.
+// Must be called with queuePriorityMutex held.
+func (tp *ThreadPool) findDispatchable() (*RequestQueue, int) {
+	if tp.pendingCount == 0 {
+		return nil, 0
+	}
+
+	if tp.maxConcurrency > 0 && tp.totalThreads-tp.lockedThreads >= tp.maxConcurrency {
+		return nil, 0
+	}
+
+	for _, p := range tp.queuePriorities {
+		queue := tp.queuePriorityMap[p]
+		for i := range *queue {
+			if (tp.paused || tp.pausedHosts[(*queue)[i].host]) && !(*queue)[i].nested {
 				continue
 			}
+			if tp.maxConcurrencyPerHost <= 0 || tp.hostThreads[(*queue)[i].host] < tp.maxConcurrencyPerHost {
+				return queue, i
+			}
+		}
+	}
+
+	return nil, 0
+}
+
+// dequeue removes the next dispatchable request and accounts for it as in flight.
+func (tp *ThreadPool) dequeue() (PendingRequest, bool) {
+	tp.queuePriorityMutex.Lock()
+	defer tp.queuePriorityMutex.Unlock()
+
+	queue, i := tp.findDispatchable()
+	if queue == nil {
+		return PendingRequest{}, false
+	}
+
+	uow := (*queue)[i]
+	if i == 0 {
+		(*queue)[0] = PendingRequest{}
+		*queue = (*queue)[1:]
+	} else {
+		*queue = append((*queue)[:i], (*queue)[i+1:]...)
+	}
+	tp.pendingCount--
+
+	tp.totalThreads++
+	tp.hostThreads[uow.host]++
+
+	return uow, true
+}
+
+// remove takes a queued message out of its queue, it returns false if the message is not queued.
+func (tp *ThreadPool) remove(msg *MessageDuplex) bool {
+	tp.queuePriorityMutex.Lock()
+	defer tp.queuePriorityMutex.Unlock()
+
+	for _, p := range tp.queuePriorities {
+		queue := tp.queuePriorityMap[p]
+		for i := range *queue {
+			if (*queue)[i].Message == msg {
+				*queue = append((*queue)[:i], (*queue)[i+1:]...)
+				tp.pendingCount--
+				tp.notifyStateChange()
+				return true
+			}
+		}
+	}
 
-			return <-queue
+	return false
+}
+
+// removeWhere removes and returns every queued request matching the predicate.
+func (tp *ThreadPool) removeWhere(predicate func(uow PendingRequest) bool) []PendingRequest {
+	tp.queuePriorityMutex.Lock()
+	defer tp.queuePriorityMutex.Unlock()
+
+	removed := []PendingRequest{}
+	for _, p := range tp.queuePriorities {
+		queue := tp.queuePriorityMap[p]
+		kept := RequestQueue{}
+		for _, uow := range *queue {
+			if predicate(uow) {
+				removed = append(removed, uow)
+			} else {
+				kept = append(kept, uow)
+			}
 		}
+		*queue = kept
 	}
+	tp.pendingCount -= len(removed)
+	tp.notifyStateChange()
+
+	return removed
 }
 
-func (tp *ThreadPool) getPendingCount() int {
-	sum := 0
+// drain removes and returns every queued request.
+func (tp *ThreadPool) drain() []PendingRequest {
+	tp.queuePriorityMutex.Lock()
+	defer tp.queuePriorityMutex.Unlock()
 
-	tp.queuePriorityMutex.RLock()
-	for p := range tp.queuePriorityMap {
-		sum += len(tp.queuePriorityMap[p])
+	drained := []PendingRequest{}
+	for _, p := range tp.queuePriorities {
+		drained = append(drained, *tp.queuePriorityMap[p]...)
+		*tp.queuePriorityMap[p] = RequestQueue{}
 	}
-	tp.queuePriorityMutex.RUnlock()
+	tp.pendingCount = 0
+	tp.notifyStateChange()
 
-	return sum
+	return drained
 }
 
-func (tp *ThreadPool) sleepIfNeeded() {
+func (tp *ThreadPool) getPendingCount() int {
+	tp.queuePriorityMutex.Lock()
+	defer tp.queuePriorityMutex.Unlock()
 
+	return tp.pendingCount
+}
+
+func (tp *ThreadPool) sleepIfNeeded() {
 	sTime := tp.minDelay + rand.Float64()*(tp.maxDelay-tp.minDelay)
-	sleepDuration, _ := time.ParseDuration(fmt.Sprintf("%dms", int(sTime*1000)))
+	if sTime <= 0 {
+		return
+	}
 
 	select {
 	case <-tp.context.Done():
-	case <-time.After(sleepDuration):
+	case <-time.After(time.Duration(sTime * float64(time.Second))):
 	}
 }
 
-func (tp *ThreadPool) work(workerID int) {
-	for {
-		select{
-		case <-tp.context.Done():
-			return
-		default:
-			uow := tp.getNextPrioritizedRequest()
-
-			tp.processCallback(uow)
-			tp.Rate.Tick(time.Now())
-
-			if tp.threadLimiter && (tp.Rate.CurrentRate() > int64(tp.Rate.RPS) || tp.getPendingCount() == 0) {
-
-				tp.threadLimiter = false
-
-				if len(tp.totalThreads) - len(tp.lockedThreads) > 1 {
-					<-tp.totalThreads
-					return
-				} else if tp.getPendingCount() != 0 {
-					tp.minDelay += 0.1
-					tp.maxDelay += 0.1
-				}
-			} else if tp.minDelay >= 0.1 {
-				tp.minDelay -= 0.1
-				tp.maxDelay -= 0.1
-			}
+// awaitNested blocks a worker until a request it queued itself is resolved,
+// marking the worker as locked so that it does not count towards the concurrency limits.
+func (tp *ThreadPool) awaitNested(host string, done <-chan struct{}) {
+	tp.queuePriorityMutex.Lock()
+	tp.lockedThreads++
+	tp.hostThreads[host]--
+	tp.queuePriorityMutex.Unlock()
+	tp.signal()
 
-			tp.sleepIfNeeded()
-		}
+	select {
+	case <-tp.context.Done():
+	case <-done:
 	}
-}
\ No newline at end of file
+
+	tp.queuePriorityMutex.Lock()
+	tp.lockedThreads--
+	tp.hostThreads[host]++
+	tp.queuePriorityMutex.Unlock()
+}
+
+func (tp *ThreadPool) work(uow PendingRequest) {
+	defer tp.release(uow.host)
+
+	tp.processCallback(uow)
+	tp.Rate.Tick(time.Now())
+
+	// the delay paces each worker, its slot is only released afterwards
+	tp.sleepIfNeeded()
+}
+
+func (tp *ThreadPool) release(host string) {
+	tp.queuePriorityMutex.Lock()
+	tp.totalThreads--
+	tp.hostThreads[host]--
+	if tp.hostThreads[host] == 0 {
+		delete(tp.hostThreads, host)
+	}
+	tp.notifyStateChange()
+	tp.queuePriorityMutex.Unlock()
+
+	tp.signal()
+}
@@ -0,0 +1,211 @@
+package httpc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tagRecorder collects the tags of processed requests in processing order.
+type tagRecorder struct {
+	mutex sync.Mutex
+	tags  []string
+}
+
+func (r *tagRecorder) record(uow PendingRequest) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.tags = append(r.tags, uow.Message.Tags[0])
+}
+
+func (r *tagRecorder) get() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]string{}, r.tags...)
+}
+
+func assertTags(t *testing.T, got []string, want ...string) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestThreadPoolPriority(t *testing.T) {
+	processed := &tagRecorder{}
+	tp := newTestPool(t, PerformanceOptions{MaxConcurrency: 1}, processed.record)
+
+	tp.Pause()
+	tp.Enqueue(newTestRequest(t, "http://a.test/", 1, "low-1"))
+	tp.Enqueue(newTestRequest(t, "http://a.test/", 3, "high"))
+	tp.Enqueue(newTestRequest(t, "http://a.test/", 2, "medium"))
+	tp.Enqueue(newTestRequest(t, "http://a.test/", 1, "low-2"))
+	tp.Resume()
+	waitPool(t, tp)
+
+	assertTags(t, processed.get(), "high", "medium", "low-1", "low-2")
+}
+
+func TestThreadPoolConcurrencyLimits(t *testing.T) {
+	tests := []struct {
+		name            string
+		opts            PerformanceOptions
+		wantPeakGlobal  int
+		wantPeakPerHost int
+	}{
+		{"global", PerformanceOptions{MaxConcurrency: 3}, 3, 3},
+		{"per host", PerformanceOptions{MaxConcurrencyPerHost: 2}, 4, 2},
+		{"both", PerformanceOptions{MaxConcurrency: 3, MaxConcurrencyPerHost: 2}, 3, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mutex sync.Mutex
+			global, peakGlobal := 0, 0
+			perHost, peakPerHost := map[string]int{}, 0
+
+			tp := newTestPool(t, tt.opts, func(uow PendingRequest) {
+				mutex.Lock()
+				global++
+				perHost[uow.host]++
+				peakGlobal = max(peakGlobal, global)
+				peakPerHost = max(peakPerHost, perHost[uow.host])
+				mutex.Unlock()
+
+				time.Sleep(10 * time.Millisecond)
+
+				mutex.Lock()
+				global--
+				perHost[uow.host]--
+				mutex.Unlock()
+			})
+
+			for i := 0; i < 10; i++ {
+				tp.Enqueue(newTestRequest(t, "http://a.test/", 1, "a"))
+				tp.Enqueue(newTestRequest(t, "http://b.test/", 1, "b"))
+			}
+			waitPool(t, tp)
+
+			if peakGlobal > tt.wantPeakGlobal {
+				t.Errorf("expected at most %d requests in flight, peak was %d", tt.wantPeakGlobal, peakGlobal)
+			}
+			if peakPerHost > tt.wantPeakPerHost {
+				t.Errorf("expected at most %d requests in flight per host, peak was %d", tt.wantPeakPerHost, peakPerHost)
+			}
+			if tp.GetThreadCount() != 0 {
+				t.Errorf("expected no threads after draining, got %d", tp.GetThreadCount())
+			}
+		})
+	}
+}
+
+func TestThreadPoolPauseHost(t *testing.T) {
+	processed := &tagRecorder{}
+	tp := newTestPool(t, PerformanceOptions{}, processed.record)
+
+	tp.PauseHost("a.test")
+	paused := newTestRequest(t, "http://a.test/", 1, "paused")
+	nested := newTestRequest(t, "http://a.test/", 1, "nested")
+	nested.nested = true
+	tp.Enqueue(paused)
+	tp.Enqueue(nested)
+	tp.Enqueue(newTestRequest(t, "http://b.test/", 1, "other"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := tp.Wait(ctx); err == nil {
+		t.Fatal("expected the request to the paused host to stay queued")
+	}
+
+	got := processed.get()
+	if len(got) != 2 || (got[0] != "nested" && got[1] != "nested") {
+		t.Fatalf("expected nested and other requests to be processed, got %v", got)
+	}
+
+	tp.ResumeHost("a.test")
+	waitPool(t, tp)
+	assertTags(t, processed.get()[2:], "paused")
+}
+
+func TestThreadPoolAwaitNested(t *testing.T) {
+	var tp *ThreadPool
+	processed := &tagRecorder{}
+	tp = newTestPool(t, PerformanceOptions{MaxConcurrency: 1, MaxConcurrencyPerHost: 1}, func(uow PendingRequest) {
+		processed.record(uow)
+		if uow.nested {
+			uow.future.complete(uow.Message)
+			return
+		}
+
+		// the nested request can only be dispatched if the waiting worker is not counted
+		nested := newTestRequest(t, "http://a.test/", 1, "nested")
+		nested.nested = true
+		tp.Enqueue(nested)
+		tp.awaitNested(uow.host, nested.future.Done())
+		if tp.GetLockedThreadCount() != 0 {
+			t.Errorf("expected the worker to be unlocked, got %d locked", tp.GetLockedThreadCount())
+		}
+	})
+
+	tp.Enqueue(newTestRequest(t, "http://a.test/", 1, "outer"))
+	waitPool(t, tp)
+
+	assertTags(t, processed.get(), "outer", "nested")
+}
+
+func TestThreadPoolRemoveAndDrain(t *testing.T) {
+	tp := newTestPool(t, PerformanceOptions{}, func(uow PendingRequest) {})
+
+	tp.Pause()
+	first := newTestRequest(t, "http://a.test/", 1, "first")
+	tp.Enqueue(first)
+	tp.Enqueue(newTestRequest(t, "http://b.test/", 2, "second"))
+	tp.Enqueue(newTestRequest(t, "http://a.test/", 1, "third"))
+
+	if !tp.remove(first.Message) {
+		t.Fatal("expected the queued message to be removed")
+	}
+	if tp.remove(first.Message) {
+		t.Fatal("expected a removed message not to be found again")
+	}
+
+	removed := tp.removeWhere(func(uow PendingRequest) bool { return uow.host == "b.test" })
+	if len(removed) != 1 || removed[0].Message.Tags[0] != "second" {
+		t.Fatalf("expected the request to b.test to be removed, got %d requests", len(removed))
+	}
+
+	drained := tp.drain()
+	if len(drained) != 1 || drained[0].Message.Tags[0] != "third" {
+		t.Fatalf("expected the remaining request to be drained, got %d requests", len(drained))
+	}
+
+	waitPool(t, tp)
+}
+
+func TestThreadPoolDelayPerWorker(t *testing.T) {
+	processed := &tagRecorder{}
+	tp := newTestPool(t, PerformanceOptions{MaxConcurrency: 4, Delay: Range{Min: 0.05, Max: 0.05}}, processed.record)
+
+	start := time.Now()
+	for i := 0; i < 8; i++ {
+		tp.Enqueue(newTestRequest(t, "http://a.test/", 1, "a"))
+	}
+	waitPool(t, tp)
+
+	// 4 workers pausing 50ms each take about 100ms, a delay shared by the pool would take 400ms
+	if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+		t.Fatalf("expected the delay to apply per worker, 8 requests took %s", elapsed)
+	}
+	if len(processed.get()) != 8 {
+		t.Fatalf("expected 8 requests to be processed, got %d", len(processed.get()))
+	}
+}
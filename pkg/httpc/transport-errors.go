@@ -0,0 +1,202 @@
+package httpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// TransportFailure is the classified cause of a transport error, it wraps
+// the original error so that errors.Is and errors.As keep working on it.
+type TransportFailure struct {
+	Kind TransportError
+	// Code is the TLS alert code or the HTTP/2 error code, if any
+	Code     int
+	CodeName string
+	Err      error
+}
+
+func (e *TransportFailure) Error() string {
+	if e.CodeName != "" {
+		return fmt.Sprintf("%s (%s): %s", e.Kind, e.CodeName, e.Err)
+	}
+
+	return fmt.Sprintf("%s: %s", e.Kind, e.Err)
+}
+
+func (e *TransportFailure) Unwrap() error {
+	return e.Err
+}
+
+func (e *TransportFailure) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind     TransportError
+		Code     int    `json:",omitempty"`
+		CodeName string `json:",omitempty"`
+		Message  string
+	}{e.Kind, e.Code, e.CodeName, e.Err.Error()})
+}
+
+var (
+	goAwayPattern      = regexp.MustCompile(`(server sent|received) GOAWAY.*ErrCode[=:]([A-Z_0-9]+)`)
+	streamResetPattern = regexp.MustCompile(`stream error: stream ID \d+; ([A-Z_0-9]+)`)
+	// the transport reports a connection error when the server breaks the HTTP/2 framing
+	connectionErrorPattern = regexp.MustCompile(`connection error: ([A-Z_0-9]+)`)
+)
+
+// tlsAlertCodes maps the descriptions crypto/tls gives remote alerts to their codes,
+// remote alerts are returned as an unexported type so the code can't be read directly.
+var tlsAlertCodes = map[string]int{
+	"close notify":                    0,
+	"unexpected message":              10,
+	"bad record MAC":                  20,
+	"decryption failed":               21,
+	"record overflow":                 22,
+	"decompression failure":           30,
+	"handshake failure":               40,
+	"bad certificate":                 42,
+	"unsupported certificate":         43,
+	"revoked certificate":             44,
+	"expired certificate":             45,
+	"unknown certificate":             46,
+	"illegal parameter":               47,
+	"unknown certificate authority":   48,
+	"access denied":                   49,
+	"error decoding message":          50,
+	"error decrypting message":        51,
+	"export restriction":              60,
+	"protocol version not supported":  70,
+	"insufficient security level":     71,
+	"internal error":                  80,
+	"inappropriate fallback":          86,
+	"user canceled":                   90,
+	"no renegotiation":                100,
+	"missing extension":               109,
+	"unsupported extension":           110,
+	"certificate unobtainable":        111,
+	"unrecognized name":               112,
+	"bad certificate status response": 113,
+	"bad certificate hash value":      114,
+	"unknown PSK identity":            115,
+	"certificate required":            116,
+	"no application protocol":         120,
+	"encrypted client hello required": 121,
+}
+
+var unknownTlsAlertPattern = regexp.MustCompile(`^alert\((\d+)\)$`)
+
+// tlsAlertCode returns the code of a TLS alert, or -1 if it is unknown.
+func tlsAlertCode(err error) int {
+	var alertErr tls.AlertError
+	if errors.As(err, &alertErr) {
+		return int(alertErr)
+	}
+
+	name := strings.TrimPrefix(err.Error(), "tls: ")
+	if code, ok := tlsAlertCodes[name]; ok {
+		return code
+	}
+	if match := unknownTlsAlertPattern.FindStringSubmatch(name); match != nil {
+		if code, err := strconv.Atoi(match[1]); err == nil {
+			return code
+		}
+	}
+
+	return -1
+}
+
+var http2ErrCodes = map[string]int{
+	"NO_ERROR":            0x0,
+	"PROTOCOL_ERROR":      0x1,
+	"INTERNAL_ERROR":      0x2,
+	"FLOW_CONTROL_ERROR":  0x3,
+	"SETTINGS_TIMEOUT":    0x4,
+	"STREAM_CLOSED":       0x5,
+	"FRAME_SIZE_ERROR":    0x6,
+	"REFUSED_STREAM":      0x7,
+	"CANCEL":              0x8,
+	"COMPRESSION_ERROR":   0x9,
+	"CONNECT_ERROR":       0xa,
+	"ENHANCE_YOUR_CALM":   0xb,
+	"INADEQUATE_SECURITY": 0xc,
+	"HTTP_1_1_REQUIRED":   0xd,
+}
+
+// classifyTransportError determines the kind of a transport error,
+// net/http doesn't export most of its error types so some are matched by message.
+func classifyTransportError(err error) *TransportFailure {
+	failure := &TransportFailure{Kind: UnknownError, Err: err}
+	if errors.As(err, &failure) {
+		return failure
+	}
+	message := err.Error()
+
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var certInvalidErr x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+	var recordHeaderErr tls.RecordHeaderError
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		failure.Kind = Cancelled
+	case errors.As(err, &opErr) && (opErr.Op == "proxyconnect" || opErr.Op == "socks connect"),
+		strings.Contains(message, "proxyconnect"):
+		failure.Kind = ProxyError
+	case os.IsTimeout(err), errors.Is(err, context.DeadlineExceeded), errors.Is(err, syscall.ETIME), errors.Is(err, syscall.ETIMEDOUT):
+		failure.Kind = Timeout
+	case errors.As(err, &dnsErr):
+		failure.Kind = DnsError
+	case errors.Is(err, syscall.ECONNREFUSED):
+		failure.Kind = ConnectionRefused
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		failure.Kind = HostUnreachable
+	case errors.As(err, &opErr) && opErr.Op == "remote error":
+		failure.Kind = TlsHandshakeAlert
+		failure.CodeName = strings.TrimPrefix(opErr.Err.Error(), "tls: ")
+		if code := tlsAlertCode(opErr.Err); code >= 0 {
+			failure.Code = code
+		}
+	case errors.As(err, &certErr), errors.As(err, &unknownAuthorityErr), errors.As(err, &certInvalidErr), errors.As(err, &hostnameErr):
+		failure.Kind = CertificateError
+	case errors.As(err, &recordHeaderErr), strings.Contains(message, "tls: "):
+		failure.Kind = TlsNegotiationFailure
+	case goAwayPattern.MatchString(message):
+		failure.Kind = Http2GoAway
+		failure.CodeName = goAwayPattern.FindStringSubmatch(message)[2]
+		failure.Code = http2ErrCodes[failure.CodeName]
+	case streamResetPattern.MatchString(message):
+		failure.Kind = Http2StreamReset
+		failure.CodeName = streamResetPattern.FindStringSubmatch(message)[1]
+		failure.Code = http2ErrCodes[failure.CodeName]
+	case connectionErrorPattern.MatchString(message):
+		failure.Kind = MalformedResponse
+		failure.CodeName = connectionErrorPattern.FindStringSubmatch(message)[1]
+		failure.Code = http2ErrCodes[failure.CodeName]
+	case errors.Is(err, syscall.ECONNRESET),
+		strings.Contains(message, "An existing connection was forcibly closed"),
+		strings.Contains(message, "client connection force closed via ClientConn.Close"):
+		failure.Kind = ConnectionReset
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		failure.Kind = EofBeforeHeaders
+	case strings.Contains(message, "malformed"), strings.Contains(message, "http2: invalid"),
+		strings.Contains(message, "http2: server sent invalid"):
+		failure.Kind = MalformedResponse
+	case strings.Contains(message, "unsupported protocol scheme"):
+		failure.Kind = UnsupportedProtocolScheme
+	}
+
+	return failure
+}
@@ -0,0 +1,106 @@
+package httpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+
+	"golang.org/x/net/http2"
+)
+
+func TestClassifyTransportError(t *testing.T) {
+	dial := func(err error) error {
+		return &url.Error{Op: "Get", URL: "http://a.test/", Err: &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", err)}}
+	}
+	remoteAlert := func(err error) error {
+		return &url.Error{Op: "Get", URL: "https://a.test/", Err: &net.OpError{Op: "remote error", Err: err}}
+	}
+
+	tests := []struct {
+		name         string
+		err          error
+		wantKind     TransportError
+		wantCode     int
+		wantCodeName string
+	}{
+		{"cancelled", context.Canceled, Cancelled, 0, ""},
+		{"deadline", &url.Error{Op: "Get", URL: "http://a.test/", Err: context.DeadlineExceeded}, Timeout, 0, ""},
+		{"timeout", &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, Timeout, 0, ""},
+		{"dns", &net.DNSError{Err: "no such host", Name: "a.test", IsNotFound: true}, DnsError, 0, ""},
+		{"refused", dial(syscall.ECONNREFUSED), ConnectionRefused, 0, ""},
+		{"reset", dial(syscall.ECONNRESET), ConnectionReset, 0, ""},
+		{"unreachable", dial(syscall.EHOSTUNREACH), HostUnreachable, 0, ""},
+		{"proxy", &net.OpError{Op: "proxyconnect", Err: syscall.ECONNREFUSED}, ProxyError, 0, ""},
+		{"tls alert", remoteAlert(tls.AlertError(40)), TlsHandshakeAlert, 40, "handshake failure"},
+		{"tls alert by name", remoteAlert(errors.New("tls: protocol version not supported")), TlsHandshakeAlert, 70, "protocol version not supported"},
+		{"unknown tls alert", remoteAlert(errors.New("tls: alert(200)")), TlsHandshakeAlert, 200, "alert(200)"},
+		{"certificate", x509.UnknownAuthorityError{}, CertificateError, 0, ""},
+		{"tls record", tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}, TlsNegotiationFailure, 0, ""},
+		{"goaway", http2.GoAwayError{LastStreamID: 1, ErrCode: http2.ErrCodeEnhanceYourCalm}, Http2GoAway, 0xb, "ENHANCE_YOUR_CALM"},
+		{"goaway after the frame", errors.New("http2: Transport received GOAWAY from server ErrCode:PROTOCOL_ERROR"), Http2GoAway, 0x1, "PROTOCOL_ERROR"},
+		{"stream reset", http2.StreamError{StreamID: 3, Code: http2.ErrCodeRefusedStream}, Http2StreamReset, 0x7, "REFUSED_STREAM"},
+		{"connection error", http2.ConnectionError(http2.ErrCodeFrameSize), MalformedResponse, 0x6, "FRAME_SIZE_ERROR"},
+		{"eof", &url.Error{Op: "Get", URL: "http://a.test/", Err: io.EOF}, EofBeforeHeaders, 0, ""},
+		{"malformed", errors.New(`net/http: HTTP/1.x transport connection broken: malformed HTTP status code "abc"`), MalformedResponse, 0, ""},
+		{"scheme", errors.New(`unsupported protocol scheme "ftp"`), UnsupportedProtocolScheme, 0, ""},
+		{"unknown", errors.New("something else"), UnknownError, 0, ""},
+		{"already classified", &TransportFailure{Kind: CircuitOpen, Err: ErrCircuitOpen}, CircuitOpen, 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failure := classifyTransportError(tt.err)
+			if failure.Kind != tt.wantKind || failure.Code != tt.wantCode || failure.CodeName != tt.wantCodeName {
+				t.Fatalf("expected %s %d %q, got %s %d %q", tt.wantKind, tt.wantCode, tt.wantCodeName, failure.Kind, failure.Code, failure.CodeName)
+			}
+			if !errors.Is(failure, tt.err) {
+				t.Fatal("expected the failure to wrap the original error")
+			}
+		})
+	}
+}
+
+func TestTransportErrorJson(t *testing.T) {
+	// the names used before the taxonomy was extended have to keep their values
+	old := map[string]TransportError{
+		"NoError": 0, "Timeout": 1, "ConnectionReset": 2, "TlsNegotiationFailure": 3, "DnsError": 4,
+		"UnsupportedProtocolScheme": 5, "UnknownError": 6, "Cancelled": 7, "Halted": 8,
+	}
+	for name, want := range old {
+		var kind TransportError
+		if err := json.Unmarshal([]byte(`"`+name+`"`), &kind); err != nil || kind != want {
+			t.Fatalf("expected %q to decode to %d, got %d (%v)", name, want, kind, err)
+		}
+	}
+
+	for kind := range transportErrorNames {
+		data, err := json.Marshal(TransportError(kind))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded TransportError
+		if err := json.Unmarshal(data, &decoded); err != nil || decoded != TransportError(kind) {
+			t.Fatalf("expected %s to round-trip, got %s (%v)", data, decoded, err)
+		}
+	}
+
+	// as map keys, e.g. in Stats
+	data, _ := json.Marshal(map[TransportError]int{Http2GoAway: 1})
+	var counts map[TransportError]int
+	if err := json.Unmarshal(data, &counts); err != nil || counts[Http2GoAway] != 1 {
+		t.Fatalf("expected map keys to round-trip, got %s (%v)", data, err)
+	}
+
+	var kind TransportError
+	if err := json.Unmarshal([]byte(`"NotAnError"`), &kind); err == nil {
+		t.Fatal("expected unknown names to fail")
+	}
+}
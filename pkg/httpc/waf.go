@@ -0,0 +1,174 @@
+package httpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// wafBodyScanLimit is how much of a response body is matched against body signatures.
+const wafBodyScanLimit = 64 * 1024
+
+type WafVerdict int
+
+const (
+	WafBlock WafVerdict = iota
+	WafChallenge
+	WafCaptcha
+)
+
+func (v WafVerdict) String() string {
+	return []string{"Block", "Challenge", "Captcha"}[v]
+}
+
+func (v WafVerdict) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.String())
+}
+
+func (v *WafVerdict) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+
+	for verdict := WafBlock; verdict <= WafCaptcha; verdict++ {
+		if verdict.String() == name {
+			*v = verdict
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown waf verdict %q", name)
+}
+
+// WafDetection tags a response that was identified as a WAF block, challenge or captcha page.
+type WafDetection struct {
+	Vendor  string
+	Verdict WafVerdict
+}
+
+// WafSignature identifies a vendor's block, challenge or captcha responses,
+// every condition that is set must match.
+type WafSignature struct {
+	Vendor  string
+	Verdict WafVerdict
+
+	StatusCodes []int
+	// Header is a header name and HeaderValue a pattern its value must match, any value matches if it's nil
+	Header      string
+	HeaderValue *regexp.Regexp
+	// CookiePrefix is matched against the names of the cookies set by the response
+	CookiePrefix string
+	Body         *regexp.Regexp
+}
+
+// challengeStatusCodes are the status codes of challenge and captcha pages, signatures that don't
+// key on a challenge-only header are limited to them so that ordinary pages embedding a captcha don't match.
+var challengeStatusCodes = []int{403, 429, 503}
+
+// DefaultWafSignatures are the built-in signatures, captcha and challenge signatures
+// come before block signatures so that the most specific verdict wins.
+var DefaultWafSignatures = []WafSignature{
+	{Vendor: "Cloudflare", Verdict: WafCaptcha, StatusCodes: challengeStatusCodes, Header: "Server", HeaderValue: regexp.MustCompile(`(?i)cloudflare`), Body: regexp.MustCompile(`cf-turnstile|h-captcha|g-recaptcha`)},
+	{Vendor: "Cloudflare", Verdict: WafChallenge, Header: "Cf-Mitigated", HeaderValue: regexp.MustCompile(`(?i)challenge`)},
+	{Vendor: "Cloudflare", Verdict: WafChallenge, StatusCodes: challengeStatusCodes, Header: "Server", HeaderValue: regexp.MustCompile(`(?i)cloudflare`), Body: regexp.MustCompile(`/cdn-cgi/challenge-platform/|<title>Just a moment\.\.\.</title>`)},
+	{Vendor: "Cloudflare", Verdict: WafBlock, StatusCodes: []int{403}, Header: "Server", HeaderValue: regexp.MustCompile(`(?i)cloudflare`), Body: regexp.MustCompile(`Attention Required! \| Cloudflare|cf-error-details|Sorry, you have been blocked`)},
+
+	{Vendor: "Akamai", Verdict: WafChallenge, StatusCodes: challengeStatusCodes, CookiePrefix: "_abck", Body: regexp.MustCompile(`sec-if-cpt-container|/_sec/cp_challenge/`)},
+	{Vendor: "Akamai", Verdict: WafBlock, StatusCodes: []int{403}, Header: "Server", HeaderValue: regexp.MustCompile(`(?i)AkamaiGHost`), Body: regexp.MustCompile(`Access Denied|Reference&#32;&#35;|Reference #`)},
+
+	{Vendor: "Imperva", Verdict: WafCaptcha, StatusCodes: challengeStatusCodes, Body: regexp.MustCompile(`(?is)incapsula.*captcha`)},
+	{Vendor: "Imperva", Verdict: WafBlock, Body: regexp.MustCompile(`Incapsula incident ID|_Incapsula_Resource|Request unsuccessful\. Incapsula`)},
+	{Vendor: "Imperva", Verdict: WafBlock, StatusCodes: []int{403}, Header: "X-Iinfo"},
+
+	{Vendor: "AWS WAF", Verdict: WafCaptcha, Header: "X-Amzn-Waf-Action", HeaderValue: regexp.MustCompile(`(?i)captcha`)},
+	{Vendor: "AWS WAF", Verdict: WafChallenge, Header: "X-Amzn-Waf-Action", HeaderValue: regexp.MustCompile(`(?i)challenge`)},
+	{Vendor: "AWS WAF", Verdict: WafChallenge, StatusCodes: []int{202, 405}, Body: regexp.MustCompile(`AwsWafIntegration|awswaf\.com`)},
+	{Vendor: "AWS WAF", Verdict: WafBlock, Header: "X-Amzn-Waf-Action", HeaderValue: regexp.MustCompile(`(?i)block`)},
+	{Vendor: "AWS WAF", Verdict: WafBlock, StatusCodes: []int{403}, Body: regexp.MustCompile(`Request blocked\.[\s\S]*Generated by cloudfront`)},
+
+	{Vendor: "DataDome", Verdict: WafCaptcha, StatusCodes: challengeStatusCodes, Body: regexp.MustCompile(`captcha-delivery\.com`)},
+	{Vendor: "DataDome", Verdict: WafBlock, StatusCodes: []int{403}, Header: "X-Datadome"},
+	{Vendor: "DataDome", Verdict: WafBlock, StatusCodes: []int{403}, CookiePrefix: "datadome"},
+}
+
+// DetectWaf matches a response against the given signatures, body is the decoded response body.
+func DetectWaf(resp *http.Response, body []byte, signatures []WafSignature) *WafDetection {
+	if len(body) > wafBodyScanLimit {
+		body = body[:wafBodyScanLimit]
+	}
+
+	for _, sig := range signatures {
+		if sig.matches(resp, body) {
+			return &WafDetection{Vendor: sig.Vendor, Verdict: sig.Verdict}
+		}
+	}
+
+	return nil
+}
+
+func (sig WafSignature) matches(resp *http.Response, body []byte) bool {
+	if len(sig.StatusCodes) > 0 && !containsStatus(sig.StatusCodes, resp.StatusCode) {
+		return false
+	}
+
+	if sig.Header != "" {
+		values := resp.Header.Values(sig.Header)
+		if len(values) == 0 {
+			return false
+		}
+		if sig.HeaderValue != nil && !sig.HeaderValue.MatchString(strings.Join(values, ",")) {
+			return false
+		}
+	}
+
+	if sig.CookiePrefix != "" {
+		found := false
+		for _, cookie := range resp.Cookies() {
+			if strings.HasPrefix(cookie.Name, sig.CookiePrefix) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if sig.Body != nil && !sig.Body.Match(body) {
+		return false
+	}
+
+	return sig.StatusCodes != nil || sig.Header != "" || sig.CookiePrefix != "" || sig.Body != nil
+}
+
+func containsStatus(statusCodes []int, statusCode int) bool {
+	for _, s := range statusCodes {
+		if s == statusCode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WafDetectionMiddleware tags responses identified as WAF block, challenge or captcha pages.
+type WafDetectionMiddleware struct{}
+
+func (WafDetectionMiddleware) ProcessResponse(c *HttpClient, msg *MessageDuplex, opts ClientOptions) (*FollowUp, error) {
+	if !opts.WafDetection.Enabled {
+		return nil, nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(msg.ResponseBody.Reader(), wafBodyScanLimit))
+
+	signatures := make([]WafSignature, 0, len(opts.WafDetection.Signatures)+len(DefaultWafSignatures))
+	signatures = append(signatures, opts.WafDetection.Signatures...)
+	signatures = append(signatures, DefaultWafSignatures...)
+	msg.Waf = DetectWaf(msg.Response, body, signatures)
+
+	return nil, nil
+}
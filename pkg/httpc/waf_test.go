@@ -0,0 +1,44 @@
+package httpc
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDetectWaf(t *testing.T) {
+	cloudflare := http.Header{"Server": {"cloudflare"}}
+
+	tests := []struct {
+		name   string
+		code   int
+		header http.Header
+		body   string
+		want   *WafDetection
+	}{
+		{"cloudflare challenge", 403, cloudflare, `<title>Just a moment...</title>`, &WafDetection{Vendor: "Cloudflare", Verdict: WafChallenge}},
+		{"cloudflare captcha", 403, cloudflare, `<div class="cf-turnstile"></div>`, &WafDetection{Vendor: "Cloudflare", Verdict: WafCaptcha}},
+		{"cloudflare block", 403, cloudflare, `Sorry, you have been blocked`, &WafDetection{Vendor: "Cloudflare", Verdict: WafBlock}},
+		{"challenge header", 200, http.Header{"Cf-Mitigated": {"challenge"}}, ``, &WafDetection{Vendor: "Cloudflare", Verdict: WafChallenge}},
+		{"datadome captcha", 403, nil, `<script src="https://ct.captcha-delivery.com/c.js">`, &WafDetection{Vendor: "DataDome", Verdict: WafCaptcha}},
+
+		// ordinary pages served through a WAF that embed a captcha widget or mention a vendor
+		{"page with captcha", 200, cloudflare, `<form><div class="g-recaptcha"></div></form>`, nil},
+		{"page with challenge script", 200, cloudflare, `<script src="/cdn-cgi/challenge-platform/scripts/jsd/main.js"></script>`, nil},
+		{"page with datadome", 200, nil, `<script src="https://ct.captcha-delivery.com/c.js">`, nil},
+		{"not found", 404, cloudflare, `Not Found`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.code, Header: tt.header}
+			if resp.Header == nil {
+				resp.Header = http.Header{}
+			}
+
+			got := DetectWaf(resp, []byte(tt.body), DefaultWafSignatures)
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package httpctest
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/aristosMiliaressis/httpc/pkg/httpc"
+)
+
+// AssertMessages checks how many messages in the client's message log match where.
+func AssertMessages(t testing.TB, c *httpc.HttpClient, where func(msg *httpc.MessageDuplex) bool, want int) {
+	t.Helper()
+
+	if got := len(c.MessageLog.Search(where)); got != want {
+		t.Errorf("expected %d matching messages, got %d", want, got)
+	}
+}
+
+// AssertStatus checks how many responses had the given status code.
+func AssertStatus(t testing.TB, c *httpc.HttpClient, code int, want int) {
+	t.Helper()
+
+	if got := c.Stats().StatusCodes[code]; got != want {
+		t.Errorf("expected %d responses with status %d, got %d", want, code, got)
+	}
+}
+
+// AssertTransportErrors checks how many requests failed with the given transport error.
+func AssertTransportErrors(t testing.TB, c *httpc.HttpClient, kind httpc.TransportError, want int) {
+	t.Helper()
+
+	if got := c.Stats().TransportErrors[kind]; got != want {
+		t.Errorf("expected %d %s transport errors, got %d", want, kind, got)
+	}
+}
+
+// AssertNoTransportErrors checks that no request failed, cancelled requests are not counted.
+func AssertNoTransportErrors(t testing.TB, c *httpc.HttpClient) {
+	t.Helper()
+
+	for kind, count := range c.Stats().TransportErrors {
+		if kind != httpc.Cancelled && count > 0 {
+			t.Errorf("expected no transport errors, got %d %s", count, kind)
+		}
+	}
+}
+
+// AssertRedirects checks that a message exists for target that was reached through depth redirects.
+func AssertRedirects(t testing.TB, c *httpc.HttpClient, target string, depth int) {
+	t.Helper()
+
+	msg := c.MessageLog.Find(func(msg *httpc.MessageDuplex) bool {
+		return msg.Request != nil && msg.Request.URL.String() == target
+	})
+	if msg == nil {
+		t.Errorf("expected a message for %s", target)
+	} else if got := msg.RedirectDepth(); got != depth {
+		t.Errorf("expected %s to be reached through %d redirects, got %d", target, depth, got)
+	}
+}
+
+// AssertThrottled checks that the client lowered its request rate.
+func AssertThrottled(t testing.TB, c *httpc.HttpClient) {
+	t.Helper()
+
+	if stats := c.Stats(); stats.ThrottleRate <= 0 {
+		t.Errorf("expected the request rate to be throttled, desired rate %.2f, throttle rate %.2f", stats.DesiredRate, stats.ThrottleRate)
+	}
+}
+
+// AssertHalted checks that requests to the server's host were halted.
+func AssertHalted(t testing.TB, c *httpc.HttpClient, server *Server) {
+	t.Helper()
+
+	if c.HostHalted(serverHost(server)) == nil && c.Halted() == nil {
+		t.Errorf("expected requests to %s to be halted", server.URL)
+	}
+}
+
+// AssertNotHalted checks that requests to the server's host were not halted.
+func AssertNotHalted(t testing.TB, c *httpc.HttpClient, server *Server) {
+	t.Helper()
+
+	if err := c.HostHalted(serverHost(server)); err != nil {
+		t.Errorf("expected requests to %s not to be halted, got %s", server.URL, err)
+	}
+	if err := c.Halted(); err != nil {
+		t.Errorf("expected the client not to be halted, got %s", err)
+	}
+}
+
+// AssertServerRequests checks how many requests the server received.
+func AssertServerRequests(t testing.TB, server *Server, want int) {
+	t.Helper()
+
+	if got := server.Requests(); got != want {
+		t.Errorf("expected the server to receive %d requests, got %d", want, got)
+	}
+}
+
+func serverHost(server *Server) string {
+	u, _ := url.Parse(server.URL)
+	return u.Host
+}
@@ -0,0 +1,183 @@
+package httpctest
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// Behavior responds to a request in a scripted way.
+type Behavior func(w http.ResponseWriter, r *http.Request)
+
+// Status responds with the given status code and its status text as the body.
+func Status(code int) Behavior {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, http.StatusText(code), code)
+	}
+}
+
+// TooManyRequests responds with 429 and a Retry-After header if retryAfter is not zero.
+func TooManyRequests(retryAfter time.Duration) Behavior {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if retryAfter > 0 {
+			w.Header().Set("Retry-After", fmt.Sprint(int(retryAfter.Seconds())))
+		}
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+	}
+}
+
+// Respond responds with the given status, headers and body.
+func Respond(code int, header http.Header, body string) Behavior {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(code)
+		fmt.Fprint(w, body)
+	}
+}
+
+// Sequence applies the behaviors in turn, starting over after the last one.
+func Sequence(behaviors ...Behavior) Behavior {
+	var n atomic.Uint64
+	return func(w http.ResponseWriter, r *http.Request) {
+		behaviors[(n.Add(1)-1)%uint64(len(behaviors))](w, r)
+	}
+}
+
+// Reset resets the connection, for HTTP/2 requests only the stream is reset.
+func Reset() Behavior {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, ok := hijack(w)
+		if !ok {
+			panic(http.ErrAbortHandler)
+		}
+
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.SetLinger(0)
+		}
+		conn.Close()
+	}
+}
+
+// Stall waits for the given duration or until the client gives up before responding with an empty body.
+func Stall(d time.Duration) Behavior {
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(d):
+		case <-r.Context().Done():
+		}
+	}
+}
+
+// PartialBody announces the full body but closes the connection after sending the first n bytes of it.
+func PartialBody(body string, n int) Behavior {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, body[:min(n, len(body))])
+		w.(http.Flusher).Flush()
+
+		conn, ok := hijack(w)
+		if !ok {
+			panic(http.ErrAbortHandler)
+		}
+		conn.Close()
+	}
+}
+
+// Redirect redirects to location with the given status code.
+func Redirect(location string, code int) Behavior {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, location, code)
+	}
+}
+
+// RedirectLoop redirects every request back to itself.
+func RedirectLoop() Behavior {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.RequestURI(), http.StatusFound)
+	}
+}
+
+// Raw writes the given bytes to the connection and closes it,
+// for HTTP/2 requests they are written to the underlying connection as is.
+func Raw(raw string) Behavior {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 {
+			writeRaw(r, []byte(raw))
+			return
+		}
+
+		conn, ok := hijack(w)
+		if !ok {
+			panic(http.ErrAbortHandler)
+		}
+		conn.Write([]byte(raw))
+		conn.Close()
+	}
+}
+
+// Malformed sends a response with an invalid status line,
+// or a SETTINGS frame with an invalid length for HTTP/2 requests.
+func Malformed() Behavior {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 {
+			var frame bytes.Buffer
+			http2.NewFramer(&frame, nil).WriteRawFrame(http2.FrameSettings, 0, 0, []byte{0, 0, 0, 0, 0})
+			Raw(frame.String())(w, r)
+			return
+		}
+
+		Raw("HTTP/1.1 abc OK\r\nContent-Length: 0\r\n\r\n")(w, r)
+	}
+}
+
+// GoAway sends an HTTP/2 GOAWAY frame with the given error code and closes the connection,
+// HTTP/1 requests get their connection reset.
+func GoAway(code http2.ErrCode) Behavior {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			Reset()(w, r)
+			return
+		}
+
+		var frame bytes.Buffer
+		http2.NewFramer(&frame, nil).WriteGoAway(0, code, []byte(strings.ToLower(code.String())))
+		writeRaw(r, frame.Bytes())
+	}
+}
+
+func hijack(w http.ResponseWriter) (net.Conn, bool) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, false
+	}
+
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		return nil, false
+	}
+
+	return conn, true
+}
+
+// writeRaw writes directly to the HTTP/2 connection of the request and closes it.
+func writeRaw(r *http.Request, raw []byte) {
+	conn, ok := r.Context().Value(connKey{}).(*lockedConn)
+	if !ok {
+		panic(http.ErrAbortHandler)
+	}
+
+	conn.Write(raw)
+	conn.Close()
+
+	// keep the server from writing its own response on the closed connection
+	panic(http.ErrAbortHandler)
+}
@@ -0,0 +1,65 @@
+package httpctest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aristosMiliaressis/httpc/pkg/httpc"
+)
+
+// NewClient creates a client without rate limiting, delays, error thresholds or retries
+// that is closed when the test ends, configure can adjust the options before it is created.
+func NewClient(t testing.TB, configure func(opts *httpc.ClientOptions)) *httpc.HttpClient {
+	opts := httpc.DefaultOptions
+	opts.SimulateBrowserRequests = false
+	opts.Performance.RequestsPerSecond = httpc.UnlimitedRate
+	opts.Performance.Delay = httpc.Range{}
+	opts.Performance.Timeout = 5
+	opts.ErrorHandling.PercentageThreshold = 0
+	opts.ErrorHandling.VerifyIPBanIfExheeded = false
+	opts.ErrorHandling.RetryTransportFailures = false
+	if configure != nil {
+		configure(&opts)
+	}
+
+	c := httpc.NewHttpClient(opts, context.Background())
+	t.Cleanup(c.Close)
+
+	return c
+}
+
+// Send sends a request with the given body, if any, and waits for it to complete.
+func Send(t testing.TB, c *httpc.HttpClient, method string, url string, body string) *httpc.MessageDuplex {
+	t.Helper()
+
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return Await(t, c.Send(req))
+}
+
+// Await waits for a request to complete, failing the test if it takes longer than 10 seconds.
+func Await(t testing.TB, future *httpc.Future) *httpc.MessageDuplex {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	msg, err := future.Wait(ctx)
+	if err != nil {
+		t.Fatalf("request did not complete: %s", err)
+	}
+
+	return msg
+}
@@ -0,0 +1,176 @@
+// Package httpctest provides a local server that can be scripted to misbehave
+// and assertions over an httpc client, for testing httpc and tools built on it.
+package httpctest
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/http2"
+)
+
+// Rule applies a behavior to the requests matching Path once After of them have been seen.
+type Rule struct {
+	// Path is the path prefix the rule matches, empty matches every request
+	Path string
+	// After is how many matching requests are handled normally before the rule applies
+	After int
+	// Times is how many requests the rule applies to, zero applies it indefinitely
+	Times int
+	// PerClient counts requests per client ip instead of across all clients,
+	// e.g. Rule{After: 20, PerClient: true, Behavior: Status(403)} bans clients after 20 requests
+	PerClient bool
+	Behavior  Behavior
+}
+
+type rule struct {
+	Rule
+	counts map[string]int
+}
+
+// Server is a local server whose responses are scripted with rules,
+// requests that match no rule are passed to Handler.
+type Server struct {
+	*httptest.Server
+
+	// Handler handles requests no rule applies to, it responds with 200 OK if nil
+	Handler http.Handler
+
+	mutex    sync.Mutex
+	rules    []*rule
+	requests int
+	clients  map[string]int
+}
+
+// NewServer starts a plain HTTP/1 server.
+func NewServer() *Server {
+	s := newServer()
+	s.Start()
+
+	return s
+}
+
+// NewTLSServer starts a TLS server that negotiates HTTP/2.
+func NewTLSServer() *Server {
+	s := newServer()
+
+	h2 := &http2.Server{}
+	s.Config.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){
+		"h2": func(srv *http.Server, conn *tls.Conn, handler http.Handler) {
+			locked := &lockedConn{Conn: conn}
+			ctx := context.WithValue(context.Background(), connKey{}, locked)
+			h2.ServeConn(locked, &http2.ServeConnOpts{Context: ctx, BaseConfig: srv, Handler: handler})
+		},
+	}
+	s.TLS = &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+	s.StartTLS()
+
+	return s
+}
+
+func newServer() *Server {
+	s := &Server{clients: map[string]int{}}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(s.serveHTTP))
+
+	return s
+}
+
+// Script adds rules, the first rule that applies to a request handles it.
+func (s *Server) Script(rules ...Rule) *Server {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, r := range rules {
+		s.rules = append(s.rules, &rule{Rule: r, counts: map[string]int{}})
+	}
+
+	return s
+}
+
+// Reset removes every rule and resets the request counts.
+func (s *Server) Reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.rules = nil
+	s.requests = 0
+	s.clients = map[string]int{}
+}
+
+// Requests returns how many requests the server received.
+func (s *Server) Requests() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.requests
+}
+
+// ClientRequests returns how many requests the server received from the given ip.
+func (s *Server) ClientRequests(ip string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.clients[ip]
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	s.mutex.Lock()
+	s.requests++
+	s.clients[ip]++
+
+	var behavior Behavior
+	for _, rule := range s.rules {
+		if !strings.HasPrefix(r.URL.Path, rule.Path) {
+			continue
+		}
+
+		key := ""
+		if rule.PerClient {
+			key = ip
+		}
+		rule.counts[key]++
+
+		n := rule.counts[key] - rule.After
+		if behavior == nil && n > 0 && (rule.Times == 0 || n <= rule.Times) {
+			behavior = rule.Behavior
+		}
+	}
+	handler := s.Handler
+	s.mutex.Unlock()
+
+	switch {
+	case behavior != nil:
+		behavior(w, r)
+	case handler != nil:
+		handler.ServeHTTP(w, r)
+	default:
+		fmt.Fprint(w, "OK")
+	}
+}
+
+type connKey struct{}
+
+// lockedConn serializes writes so frames injected by behaviors
+// don't interleave with the ones written by the HTTP/2 server.
+type lockedConn struct {
+	net.Conn
+	mutex sync.Mutex
+}
+
+func (c *lockedConn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.Conn.Write(b)
+}